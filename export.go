@@ -6,23 +6,67 @@ import (
 	"github.com/b4fun/parsimonious-go/types"
 )
 
+const (
+	DefaultErrorTokenRule = types.DefaultErrorTokenRule
+
+	SuggestionLiteral  = types.SuggestionLiteral
+	SuggestionCategory = types.SuggestionCategory
+)
+
 var (
 	NewGrammar          = bootstrap.NewGrammar
 	ParsimoniousGrammar = bootstrap.ParsimoniousGrammar
 
-	ParseWithDebug = types.ParseWithDebug
+	ParseWithDebug       = types.ParseWithDebug
+	ParseWithIncremental = types.ParseWithIncremental
+	ParseWithFuncs       = types.ParseWithFuncs
+	ParseWithState       = types.ParseWithState
+	ParseWithRecovery    = types.ParseWithRecovery
+	ParseWithSkip        = types.ParseWithSkip
+	ParseWithFS          = types.ParseWithFS
+
+	ParseTokensWithExpression = types.ParseTokensWithExpression
+	TokenSpan                 = types.TokenSpan
+
+	AnnotationToken  = types.AnnotationToken
+	AnnotationInline = types.AnnotationInline
+	AnnotationNoMemo = types.AnnotationNoMemo
 
 	DumpNodeExprTree         = nodes.DumpNodeExprTree
 	NewNodeVisitorMux        = nodes.NewNodeVisitorMux
 	WithDefaultNodeVisitFunc = nodes.WithDefaultNodeVisitFunc
+	Child                    = nodes.Child
+	ChildrenNamed            = nodes.ChildrenNamed
 )
 
 type (
-	Node       = types.Node
-	Expression = types.Expression
-	Grammar    = types.Grammar
+	Node  = types.Node
+	Group = types.Group
+	Token = types.Token
+	Lexer = types.Lexer
+
+	Suggestion     = types.Suggestion
+	SuggestionKind = types.SuggestionKind
+	Expression     = types.Expression
+	Grammar        = types.Grammar
+
+	EventHandler  = types.EventHandler
+	Funcs         = types.Funcs
+	MatchContext  = types.MatchContext
+	PredicateFunc = types.PredicateFunc
+	MatcherFunc   = types.MatcherFunc
+	State         = types.State
+	Annotations   = types.Annotations
+
+	PrecedenceVisitFunc = nodes.PrecedenceVisitFunc
+	LabeledVisitFunc    = nodes.LabeledVisitFunc
 
 	ErrParseFailed           = types.ErrParseFailed
 	ErrIncompleteParseFailed = types.ErrIncompleteParseFailed
 	ErrLeftRecursion         = types.ErrLeftRecursion
+	ErrIndentation           = types.ErrIndentation
+	ErrCutFailed             = types.ErrCutFailed
+	ErrLabeledFailure        = types.ErrLabeledFailure
+	ErrRecovered             = types.ErrRecovered
+	ErrInvalidGrammar        = types.ErrInvalidGrammar
 )
@@ -1,8 +1,13 @@
 package parsimonious
 
 import (
+	"fmt"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/dlclark/regexp2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -19,10 +24,97 @@ func Test_Grammar_ParseErrors(t *testing.T) {
 		assert.Equal(
 			t, err.Error(),
 			`rule "seq" matched in its entirely, but it didn't consume all the text. `+
-				`The non-matching portion of the text begins with "" (line 1, column 4)`,
+				`The non-matching portion of the text begins with "b" (line 1, column 4)`,
 		)
 	})
 
+	t.Run("cut", func(t *testing.T) {
+		grammar, err := NewGrammar(`
+statement = if_statement / expression
+if_statement = "if" ~> " " condition ":"
+condition = ~"[a-z]+"
+expression = ~"[a-z ]+"
+`)
+		assert.NoError(t, err)
+
+		_, err = grammar.Parse("if x:")
+		assert.NoError(t, err)
+
+		tree, err := grammar.Parse("if 1:")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.IsType(t, &ErrCutFailed{}, err)
+		assert.Equal(
+			t, err.Error(),
+			`expected "condition" after the cut in rule "if_statement" at "1:" (line 1, column 4)`,
+		)
+	})
+
+	t.Run("labeled failure", func(t *testing.T) {
+		grammar, err := NewGrammar(`
+assignment = name " "* "=" " "* value^missing_value:"expected a value" ";"^";"
+name = ~"[a-z]+"
+value = ~"[0-9]+"
+`)
+		assert.NoError(t, err)
+
+		tree, err := grammar.Parse("x = ; ")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.IsType(t, &ErrLabeledFailure{}, err)
+		assert.Equal(t, "missing_value", err.(*ErrLabeledFailure).Label)
+		assert.Equal(
+			t, err.Error(),
+			`expected a value in rule "assignment" at "; " (line 1, column 5)`,
+		)
+
+		tree, err = grammar.Parse("x = 1")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.IsType(t, &ErrLabeledFailure{}, err)
+		assert.Equal(t, "", err.(*ErrLabeledFailure).Label)
+	})
+
+	t.Run("display name", func(t *testing.T) {
+		grammar, err := NewGrammar(`
+assignment = name "=" number
+name "a name" = ~"[a-z]+"
+number "a number" = ~"[0-9]+"
+`)
+		assert.NoError(t, err)
+
+		tree, err := grammar.ParseWithRule("number", "x")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.Equal(t, err.Error(), `expected a number at "x" (line 1, column 1)`)
+
+		tree, err = grammar.Parse("x=y")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.Equal(t, err.Error(), `expected a number at "y" (line 1, column 3)`)
+
+		grammar, err = NewGrammar(`
+assignment = name "=" number^missing_number
+name "a name" = ~"[a-z]+"
+number "a number" = ~"[0-9]+"
+`)
+		assert.NoError(t, err)
+
+		tree, err = grammar.Parse("x=y ")
+		assert.Nil(t, tree)
+		assert.Error(t, err)
+		assert.Equal(t, err.Error(), `expected a number in rule "assignment" at "y " (line 1, column 3)`)
+
+		rule, ok := grammar.GetRule("number")
+		assert.True(t, ok)
+		assert.Equal(t, "number", rule.ExprName())
+		assert.Equal(t, "a number", rule.DisplayName())
+
+		// display names are plain strings without the literal flags
+		_, err = NewGrammar(`number "a number"i = ~"[0-9]+"`)
+		assert.Error(t, err)
+	})
+
 	t.Run("left recursion", func(t *testing.T) {
 		grammar, err := NewGrammar(`
 expression = operator_expression / non_operator_expression
@@ -43,8 +135,1056 @@ number_expression = ~"[0-9]+"
 		assert.IsType(t, &ErrLeftRecursion{}, err)
 		assert.Equal(
 			t, err.Error(),
-			`left recursion in rule "operator_expression" at "1+" (line 1, column 1). `+
+			`left recursion in rule "operator_expression" at "1+2" (line 1, column 1). `+
 				`Please rewrite your grammar into a rule that does not use left recursion.`,
 		)
 	})
+
+	t.Run("end of input", func(t *testing.T) {
+		cases := []struct {
+			grammar  string
+			text     string
+			expected string
+		}{
+			{
+				grammar:  `s = "a" "b"`,
+				text:     "a",
+				expected: `expected "b" at "" (line 1, column 2)`,
+			},
+			{
+				grammar:  `s = "a" ~> "b"`,
+				text:     "a",
+				expected: `expected "b" after the cut in rule "s" at "" (line 1, column 2)`,
+			},
+			{
+				grammar:  `s = "a" "b"^"need b"`,
+				text:     "a",
+				expected: `need b in rule "s" at "" (line 1, column 2)`,
+			},
+			{
+				grammar: `
+s = sep_by(item, ",") ";"
+sep_by(item, sep) = item (sep item)*
+item = ~"[a-z]+"
+`,
+				text:     "a,b",
+				expected: `expected "," or ";" at "" (line 1, column 4)`,
+			},
+		}
+		for _, c := range cases {
+			grammar, err := NewGrammar(c.grammar)
+			assert.NoError(t, err)
+
+			_, err = grammar.Parse(c.text)
+			if assert.Error(t, err, c.grammar) {
+				assert.Equal(t, c.expected, err.Error(), c.grammar)
+			}
+		}
+	})
+}
+
+func Test_Grammar_NestedAnonymousExpressions(t *testing.T) {
+	grammar, err := NewGrammar(`a = "w" (("x" "y") "z")`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("wxyz")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, tree.End)
+}
+
+func Test_Grammar_Reparse(t *testing.T) {
+	grammar, err := NewGrammar(`
+statements = statement*
+statement = name _ "=" _ number _ ";" _
+name = ~"[a-z]+"
+number = ~"[0-9]+"
+_ = ~"[ \n]*"
+`)
+	assert.NoError(t, err)
+
+	text := "a = 1;\nb = 2;\nc = 3;\n"
+	tree, err := grammar.Parse(text, ParseWithIncremental(true))
+	assert.NoError(t, err)
+	lastStatement := tree.Children[2]
+
+	// replace "2" with "42"
+	newText := "a = 1;\nb = 42;\nc = 3;\n"
+	tree.Edit(11, 12, 13)
+	assert.Equal(t, 15, lastStatement.Start)
+
+	newTree, err := grammar.Reparse(tree, newText)
+	assert.NoError(t, err)
+	assert.Same(t, lastStatement, newTree.Children[2], "statement after the edit should be reused")
+	assert.Equal(t, "b = 42;\n", newTree.Children[1].Text)
+
+	freshTree, err := grammar.Parse(newText)
+	assert.NoError(t, err)
+	assert.Equal(t, DumpNodeExprTree(freshTree), DumpNodeExprTree(newTree))
+	for idx := range freshTree.Children {
+		assert.Equal(t, freshTree.Children[idx].Start, newTree.Children[idx].Start)
+		assert.Equal(t, freshTree.Children[idx].End, newTree.Children[idx].End)
+	}
+
+	// remove the last statement
+	newTree.Edit(15, 22, 15)
+	newText = "a = 1;\nb = 42;\n"
+	newTree, err = grammar.Reparse(newTree, newText)
+	assert.NoError(t, err)
+	assert.Len(t, newTree.Children, 2)
+	assert.Equal(t, newText, newTree.Text)
+}
+
+type recordingEventHandler struct {
+	events []string
+}
+
+func (h *recordingEventHandler) Enter(rule string, start int) error {
+	h.events = append(h.events, fmt.Sprintf("enter %s %d", rule, start))
+	return nil
+}
+
+func (h *recordingEventHandler) Exit(rule string, start int, end int) error {
+	h.events = append(h.events, fmt.Sprintf("exit %s %d %d", rule, start, end))
+	return nil
+}
+
+func Test_Grammar_ParseEvents(t *testing.T) {
+	grammar, err := NewGrammar(`
+sentence = exclamation / question
+exclamation = word "!"
+question = word "?"
+word = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+
+	handler := &recordingEventHandler{}
+	err = grammar.ParseEvents("hi?", handler)
+	assert.NoError(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"enter sentence 0",
+			"enter question 0",
+			"enter word 0",
+			"exit word 0 2",
+			"exit question 0 3",
+			"exit sentence 0 3",
+		},
+		handler.events,
+	)
+
+	err = grammar.ParseEvents("hi.", handler)
+	assert.Error(t, err)
+}
+
+func Test_Grammar_Funcs(t *testing.T) {
+	grammar, err := NewGrammar(`
+declarations = declaration+
+declaration = (blob / type_name) ";"
+type_name = !{is_reserved} ~"[a-z]+"
+blob = "blob:" @length_prefixed
+`)
+	assert.NoError(t, err)
+
+	grammar, err = grammar.WithFuncs(Funcs{
+		Predicates: map[string]PredicateFunc{
+			"is_reserved": func(ctx *MatchContext) (bool, error) {
+				return strings.HasPrefix(string([]rune(ctx.Text)[ctx.Pos:]), "int;"), nil
+			},
+		},
+		Matchers: map[string]MatcherFunc{
+			"length_prefixed": func(ctx *MatchContext) (int, bool, error) {
+				rest := []rune(ctx.Text)[ctx.Pos:]
+				if len(rest) < 1 || rest[0] < '0' || rest[0] > '9' {
+					return 0, false, nil
+				}
+				size := int(rest[0] - '0')
+				if len(rest) < 1+size {
+					return 0, false, nil
+				}
+				return 1 + size, true, nil
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("foo;blob:3a;b;bar;")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 3)
+
+	_, err = grammar.Parse("foo;int;")
+	assert.Error(t, err)
+
+	_, err = grammar.Parse("blob:9abc;")
+	assert.Error(t, err)
+
+	grammar, err = NewGrammar(`blob = @unknown`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("blob")
+	assert.EqualError(t, err, `matcher "unknown" is not registered`)
+}
+
+type typeNamesState struct {
+	names map[string]bool
+}
+
+func copyTypeNames(names map[string]bool) map[string]bool {
+	rv := make(map[string]bool, len(names))
+	for k, v := range names {
+		rv[k] = v
+	}
+	return rv
+}
+
+func (s *typeNamesState) Snapshot() any {
+	return copyTypeNames(s.names)
+}
+
+func (s *typeNamesState) Restore(snapshot any) {
+	// the snapshot is kept for restoring it again
+	s.names = copyTypeNames(snapshot.(map[string]bool))
+}
+
+func Test_Grammar_ParseWithState(t *testing.T) {
+	grammar, err := NewGrammar(`
+statements = statement+
+statement = typedef / failed_typedef / declaration
+typedef = "type " name @declare_type ";"
+failed_typedef = "type " name "!;"
+declaration = name " " &{is_type} name ";"
+name = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+
+	lastName := func(ctx *MatchContext) string {
+		text := []rune(ctx.Text)[:ctx.Pos]
+		start := len(text)
+		for start > 0 && text[start-1] >= 'a' && text[start-1] <= 'z' {
+			start--
+		}
+		return string(text[start:])
+	}
+	grammar, err = grammar.WithFuncs(Funcs{
+		Predicates: map[string]PredicateFunc{
+			"is_type": func(ctx *MatchContext) (bool, error) {
+				typeName := lastName(&MatchContext{Text: ctx.Text, Pos: ctx.Pos - 1})
+				return ctx.State.(*typeNamesState).names[typeName], nil
+			},
+		},
+		Matchers: map[string]MatcherFunc{
+			"declare_type": func(ctx *MatchContext) (int, bool, error) {
+				ctx.State.(*typeNamesState).names[lastName(ctx)] = true
+				ctx.StateChanged()
+				return 0, true, nil
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	state := &typeNamesState{names: map[string]bool{}}
+	_, err = grammar.Parse("type foo;foo x;", ParseWithState(state))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"foo": true}, state.names)
+
+	// the declaration of "bar" is rolled back when backtracking from typedef
+	state = &typeNamesState{names: map[string]bool{}}
+	_, err = grammar.Parse("type bar!;bar x;", ParseWithState(state))
+	assert.Error(t, err)
+	assert.Empty(t, state.names)
+}
+
+func Test_Grammar_Precedence(t *testing.T) {
+	grammar, err := NewGrammar(`
+expression = %precedence(operand) {
+    left: plus minus
+    left: ("*" _) ("/" _)
+    prefix: minus
+    right: ("^" _)
+    postfix: ("!" _)
+}
+operand = number / parenthesized
+parenthesized = "(" _ expression ")" _
+number = ~"[0-9]+" _
+plus = "+" _
+minus = "-" _
+_ = ~"[ ]*"
+`)
+	assert.NoError(t, err)
+
+	mux := NewNodeVisitorMux().
+		HandlePrecedence("expression", func(node *Node, operator *Node, operands []any) (any, error) {
+			switch op := strings.TrimSpace(operator.Text); {
+			case len(operands) == 1 && operator.Start == node.Start:
+				return fmt.Sprintf("(%s%v)", op, operands[0]), nil
+			case len(operands) == 1:
+				return fmt.Sprintf("(%v%s)", operands[0], op), nil
+			default:
+				return fmt.Sprintf("(%v %s %v)", operands[0], op, operands[1]), nil
+			}
+		}).
+		HandleExpr("operand", func(node *Node, children []any) (any, error) {
+			return children[0], nil
+		}).
+		HandleExpr("parenthesized", func(node *Node, children []any) (any, error) {
+			return children[2], nil
+		}).
+		HandleExpr("number", func(node *Node, children []any) (any, error) {
+			return strings.TrimSpace(node.Text), nil
+		})
+
+	cases := map[string]string{
+		"1":                 "1",
+		"1 - 2 - 3":         "((1 - 2) - 3)",
+		"1 + 2 * 3":         "(1 + (2 * 3))",
+		"2 ^ 3 ^ 4":         "(2 ^ (3 ^ 4))",
+		"-2 ^ 2":            "(-(2 ^ 2))",
+		"-2 * 3":            "((-2) * 3)",
+		"1 * -2":            "(1 * (-2))",
+		"3! * 2":            "((3!) * 2)",
+		"(1 + 2) * 3 / 4":   "(((1 + 2) * 3) / 4)",
+		"1 + 2 * 3 ^ 2 - 4": "((1 + (2 * (3 ^ 2))) - 4)",
+	}
+	for text, expected := range cases {
+		tree, err := grammar.Parse(text)
+		assert.NoError(t, err, text)
+
+		result, err := mux.Visit(tree)
+		assert.NoError(t, err, text)
+		assert.Equal(t, expected, result, text)
+	}
+
+	_, err = grammar.Parse("1 +")
+	assert.Error(t, err)
+}
+
+func Test_Grammar_WithRecovery(t *testing.T) {
+	grammar, err := NewGrammar(`
+statements = statement+
+statement = ~"[a-z]+" "=" ~"[0-9]+"^number ";"
+skip_to_semicolon = ~"[^;]*"
+`)
+	assert.NoError(t, err)
+
+	_, err = grammar.WithRecovery(map[string]string{"number": "unknown"})
+	assert.Error(t, err)
+
+	grammar, err = grammar.WithRecovery(map[string]string{"number": "skip_to_semicolon"})
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("a=1;b=x;c=3;")
+	assert.NotNil(t, tree)
+	assert.Error(t, err)
+	assert.IsType(t, &ErrRecovered{}, err)
+	failures := err.(*ErrRecovered).Failures
+	if assert.Len(t, failures, 1) {
+		assert.Equal(t, "number", failures[0].Label)
+		assert.Equal(t, "statement", failures[0].Rule.ExprName())
+		assert.Equal(t, 6, failures[0].Position)
+	}
+	assert.Len(t, tree.Children, 3)
+}
+
+func Test_Grammar_Skip(t *testing.T) {
+	grammar, err := NewGrammar(`
+@skip = ~r"\s+" / comment
+statements = statement+
+statement = Name "=" value ";"
+value = Number / Name
+Name = ~"[a-z]+"
+Number = digits "." digits
+digits = ~"[0-9]+"
+comment = ~r"#[^\r\n]*"
+`)
+	assert.NoError(t, err)
+
+	text := "  a = 1.5 ;\n# comment\nb=c;  "
+	tree, err := grammar.Parse(text)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tree.Start)
+	assert.Equal(t, len(text), tree.End)
+	assert.Len(t, tree.Children, 2)
+
+	statement := tree.Children[0]
+	assert.Equal(t, "statement", statement.Expression.ExprName())
+	assert.Equal(t, "a = 1.5 ;", statement.Text)
+	assert.Len(t, statement.Children, 4)
+	assert.Len(t, statement.Trivia, 3)
+
+	// the root keeps the leading and trailing trivia besides the trivia between the statements
+	if assert.Len(t, tree.Trivia, 5) {
+		assert.Equal(t, "  ", tree.Trivia[0].Text)
+		assert.Equal(t, "# comment", tree.Trivia[2].Text)
+		assert.Equal(t, "  ", tree.Trivia[4].Text)
+	}
+
+	// lexical rules match exactly, including the rules referenced from them
+	_, err = grammar.Parse("a = 1 .5;")
+	assert.Error(t, err)
+
+	_, err = NewGrammar(`
+@unknown = "x"
+a = "a"
+`)
+	assert.Error(t, err)
+}
+
+func Test_Grammar_WithSkip(t *testing.T) {
+	grammar, err := NewGrammar(`
+words = word+
+word = ~"[a-z]+"
+_ = ~r"\s+"
+`)
+	assert.NoError(t, err)
+
+	_, err = grammar.Parse("hello world")
+	assert.Error(t, err)
+
+	_, err = grammar.WithSkip("unknown")
+	assert.Error(t, err)
+
+	skipGrammar, err := grammar.WithSkip("_")
+	assert.NoError(t, err)
+
+	tree, err := skipGrammar.Parse("hello world")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 2)
+	if assert.Len(t, tree.Trivia, 1) {
+		assert.Equal(t, " ", tree.Trivia[0].Text)
+	}
+
+	// the original grammar is unchanged
+	_, err = grammar.Parse("hello world")
+	assert.Error(t, err)
+}
+
+func Test_Grammar_Annotations(t *testing.T) {
+	grammar, err := NewGrammar(`
+call = name "(" args? ")"
+@inline args = number more_args*
+@inline more_args = "," number
+@token number = ~"[0-9]+" ("." ~"[0-9]+")?
+@nomemo @token name = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+
+	rule, ok := grammar.GetRule("name")
+	assert.True(t, ok)
+	assert.True(t, rule.Annotations().Has(AnnotationToken))
+	assert.True(t, rule.Annotations().Has(AnnotationNoMemo))
+	assert.False(t, rule.Annotations().Has(AnnotationInline))
+
+	tree, err := grammar.Parse("max(1.5,2,3)")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 4)
+
+	// the inline rules are spliced into their parents
+	nodeTexts := func(nodes []*Node) []string {
+		var texts []string
+		for _, node := range nodes {
+			texts = append(texts, node.Text)
+		}
+		return texts
+	}
+	args := tree.Children[2]
+	assert.Equal(t, []string{"1.5", ",2,3"}, nodeTexts(args.Children))
+	assert.Equal(t, []string{",", "2", ",", "3"}, nodeTexts(args.Children[1].Children))
+
+	number := args.Children[0]
+	assert.Equal(t, "number", number.Expression.ExprName())
+	assert.Empty(t, number.Children)
+
+	// matchers can still be called in rules following annotations
+	grammar, err = NewGrammar(`
+blob = "<" @identifier ">"
+@token identifier = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+	grammar, err = grammar.WithFuncs(Funcs{
+		Matchers: map[string]MatcherFunc{
+			"identifier": func(ctx *MatchContext) (int, bool, error) {
+				return 1, true, nil
+			},
+		},
+	})
+	assert.NoError(t, err)
+	_, err = grammar.Parse("<a>")
+	assert.NoError(t, err)
+}
+
+func Test_Grammar_LabeledChildren(t *testing.T) {
+	grammar, err := NewGrammar(`
+assign = name:identifier _ "=" _ value:expr
+expr = num:number / ref:identifier
+@inline _ = ~r"\s*"
+identifier = ~"[a-z]+"
+number = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("x = 42")
+	assert.NoError(t, err)
+	assert.Equal(t, "x", tree.Child("name").Text)
+	assert.Equal(t, "42", tree.Child("value").Text)
+	assert.Nil(t, tree.Child("unknown"))
+	assert.Equal(t, "42", tree.Child("value").Child("num").Text)
+	assert.Nil(t, tree.Child("value").Child("ref"))
+
+	children := tree.ChildrenNamed("value", "name")
+	assert.Equal(t, "42", children[0].Text)
+	assert.Equal(t, "x", children[1].Text)
+
+	// the inline whitespaces are spliced without affecting the labels
+	assert.Equal(t, "name", tree.ChildLabel(0))
+	assert.Equal(t, "", tree.ChildLabel(1))
+	assert.Equal(t, "value", tree.ChildLabel(len(tree.Children)-1))
+
+	mux := NewNodeVisitorMux().
+		HandleLabeled("assign", func(node *Node, children map[string]any) (any, error) {
+			return fmt.Sprintf("%s := %s", children["name"], children["value"]), nil
+		}).
+		HandleExpr("expr", func(node *Node, children []any) (any, error) {
+			if num, ok := Child(node, children, "num"); ok {
+				return "num(" + num.(string) + ")", nil
+			}
+			return ChildrenNamed(node, children, "ref")[0], nil
+		}).
+		HandleExpr("identifier", func(node *Node, children []any) (any, error) {
+			return node.Text, nil
+		}).
+		HandleExpr("number", func(node *Node, children []any) (any, error) {
+			return node.Text, nil
+		})
+	result, err := mux.Visit(tree)
+	assert.NoError(t, err)
+	assert.Equal(t, "x := num(42)", result)
+
+	_, err = NewGrammar(`a = value:"a"`)
+	assert.Error(t, err)
+}
+
+func Test_Grammar_CaseInsensitiveLiteral(t *testing.T) {
+	grammar, err := NewGrammar(`
+query = "select"i " " column
+column = ~"[a-z]+"i
+`)
+	assert.NoError(t, err)
+
+	for _, text := range []string{"select a", "SELECT a", "SeLeCt a"} {
+		tree, err := grammar.Parse(text)
+		assert.NoError(t, err)
+		assert.Equal(t, text[:6], tree.Children[0].Text)
+	}
+
+	rule, ok := grammar.GetRule("query")
+	assert.True(t, ok)
+	assert.Contains(t, rule.String(), `"select"i`)
+
+	// literals followed by references are not affected
+	grammar, err = NewGrammar(`
+words = "a"identifier
+identifier = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("aidentifier")
+	assert.NoError(t, err)
+	_, err = grammar.Parse("Aidentifier")
+	assert.Error(t, err)
+}
+
+func Test_Grammar_CharClassAnyCharEOF(t *testing.T) {
+	grammar, err := NewGrammar(`
+line = identifier " "* comment? EOF
+identifier = [\p{L}_] [\p{L}\d_]*
+comment = "#" [^\n]*
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("größe_1 # any thing")
+	assert.NoError(t, err)
+	assert.Equal(t, "größe_1", tree.Children[0].Text)
+
+	_, err = grammar.Parse("1abc")
+	assert.Error(t, err)
+
+	grammar, err = NewGrammar(`
+quoted = "'" (!"'" .)* "'"
+`)
+	assert.NoError(t, err)
+	tree, err = grammar.Parse("'a\"b'")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children[1].Children, 3)
+
+	_, err = NewGrammar(`a = [z-a]`)
+	assert.Error(t, err)
+}
+
+func Test_Grammar_PythonStringEscapes(t *testing.T) {
+	grammar, err := NewGrammar(`
+lines = line+
+line = ~"[a-z]+" ("\n" / "\r\n" / "\x00")
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("ab\ncd\r\nef\x00")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 3)
+
+	// as in Python, unrecognized escapes are kept for regexes
+	grammar, err = NewGrammar(`
+number = ~"\d+" ~r"\.\d+"
+`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("1.5")
+	assert.NoError(t, err)
+}
+
+func Test_Grammar_PythonRegex(t *testing.T) {
+	grammar, err := NewGrammar(`
+string = ~r"(?P<quote>['\"])(?:\\.|(?!(?P=quote)).)*(?P=quote)"
+`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse(`'say "hi"'`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse(`'say "hi"`)
+	assert.Error(t, err)
+
+	_, err = NewGrammar(`
+name = ~"[a-z]+"
+char = ~r"\N{DIGIT ONE}"
+`)
+	var invalidGrammar *ErrInvalidGrammar
+	if assert.ErrorAs(t, err, &invalidGrammar) {
+		line, column := invalidGrammar.LineAndColumn()
+		assert.Equal(t, 3, line)
+		assert.Equal(t, 8, column)
+		assert.Contains(t, err.Error(), "line 3, column 8")
+	}
+}
+
+func Test_Grammar_RegexGroups(t *testing.T) {
+	grammar, err := NewGrammar(`
+release = "v" version
+version = ~r"(?P<major>\d+)\.(?P<minor>\d+)(-(?P<pre>\w+))?"
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("v10.2")
+	assert.NoError(t, err)
+	version := tree.Children[1]
+	major := version.Group("major")
+	if assert.NotNil(t, major) {
+		assert.Equal(t, "10", major.Text)
+		assert.Equal(t, 1, major.Start)
+		assert.Equal(t, 3, major.End)
+	}
+	assert.Equal(t, "2", version.Group("minor").Text)
+	assert.Nil(t, version.Group("pre"))
+	assert.Nil(t, version.Group("patch"))
+
+	// the groups are numbered from left to right as in Python
+	groups := version.Groups()
+	assert.Len(t, groups, 4)
+	assert.Equal(t, []string{"major", "minor", "3", "pre"}, []string{groups[0].Name, groups[1].Name, groups[2].Name, groups[3].Name})
+	assert.False(t, groups[2].Matched())
+	assert.Equal(t, -1, groups[2].Start)
+
+	// positions are rune offsets
+	tree, err = grammar.Parse("v1.0-β")
+	assert.NoError(t, err)
+	pre := tree.Children[1].Group("pre")
+	if assert.NotNil(t, pre) {
+		assert.Equal(t, "β", pre.Text)
+		assert.Equal(t, 5, pre.Start)
+		assert.Equal(t, 6, pre.End)
+	}
+
+	// a named group before an unnamed one keeps its number
+	grammar, err = NewGrammar(`pair = ~r"(?P<a>x)(y)\2"`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("xyx")
+	assert.Error(t, err)
+	tree, err = grammar.Parse("xyy")
+	assert.NoError(t, err)
+	groups = tree.Groups()
+	if assert.Len(t, groups, 2) {
+		assert.Equal(t, "a", groups[0].Name)
+		assert.Equal(t, "x", groups[0].Text)
+		assert.Equal(t, "2", groups[1].Name)
+		assert.Equal(t, "y", groups[1].Text)
+	}
+	assert.Equal(t, "x", tree.Group("1").Text)
+}
+
+func Test_Grammar_Import(t *testing.T) {
+	fsys := fstest.MapFS{
+		"common.peg": {Data: []byte(`
+identifier = ~"[a-z]+"
+number = digit+
+digit = ~"[0-9]"
+`)},
+		"lang/expr.peg": {Data: []byte(`
+@import "../common.peg" as c
+sum = c.number "+" c.number
+`)},
+		"cycle/a.peg": {Data: []byte(`
+@import "b.peg" as b
+a = "a"
+`)},
+		"cycle/b.peg": {Data: []byte(`
+@import "a.peg" as a
+b = "b"
+`)},
+		"broken.peg": {Data: []byte(`
+ok = "ok"
+bad = ~r"(?Px)"
+`)},
+	}
+
+	grammar, err := NewGrammar(`
+@import "common.peg" as c
+@import "lang/expr.peg" as e
+assign = c.identifier "=" e.sum
+`, ParseWithFS(fsys))
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("x=1+23")
+	assert.NoError(t, err)
+	assert.Equal(t, "c.identifier", tree.Children[0].Expression.ExprName())
+	assert.Equal(t, "e.sum", tree.Children[2].Expression.ExprName())
+	assert.Equal(t, "e.c.number", tree.Children[2].Children[2].Expression.ExprName())
+	_, ok := grammar.GetRule("e.c.digit")
+	assert.True(t, ok)
+
+	_, err = NewGrammar(`
+@import "cycle/a.peg" as a
+start = a.a
+`, ParseWithFS(fsys))
+	assert.ErrorContains(t, err, "import cycle: cycle/a.peg -> cycle/b.peg -> cycle/a.peg (cycle/b.peg, line 2, column 1)")
+
+	_, err = NewGrammar(`
+@import "broken.peg" as b
+start = b.ok
+`, ParseWithFS(fsys))
+	var invalidGrammar *ErrInvalidGrammar
+	if assert.ErrorAs(t, err, &invalidGrammar) {
+		assert.Equal(t, "broken.peg", invalidGrammar.File)
+		line, column := invalidGrammar.LineAndColumn()
+		assert.Equal(t, 3, line)
+		assert.Equal(t, 7, column)
+	}
+
+	_, err = NewGrammar(`
+start = "a"
+@import "missing.peg" as m
+`, ParseWithFS(fsys))
+	assert.ErrorContains(t, err, "(line 3, column 1)")
+
+	_, err = NewGrammar(`
+@import "common.peg" as c
+start = c.identifier
+`)
+	assert.ErrorContains(t, err, "no file system to import from")
+}
+
+func Test_Grammar_Extend(t *testing.T) {
+	grammar, err := NewGrammar(`
+list = "[" items? "]"
+items = item ("," item)*
+item = number / list
+number = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+
+	extended, err := grammar.Extend(`
+number = ~"-?[0-9]+"
+item = number / list / name
+name = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+
+	tree, err := extended.Parse("[-1,[a,2]]")
+	assert.NoError(t, err)
+	assert.Equal(t, "list", tree.Expression.ExprName())
+
+	// the original grammar is unchanged
+	_, err = grammar.Parse("[-1,[a,2]]")
+	assert.Error(t, err)
+	_, err = grammar.Parse("[1,[2]]")
+	assert.NoError(t, err)
+	_, ok := grammar.GetRule("name")
+	assert.False(t, ok)
+
+	_, err = grammar.Extend(`item = missing`)
+	assert.ErrorContains(t, err, `"missing"`)
+
+	_, err = grammar.Extend("  # nothing")
+	assert.EqualError(t, err, "no rules to extend")
+}
+
+func Test_Grammar_WithRules(t *testing.T) {
+	grammar, err := NewGrammar(`
+@skip = ~r"\s+"
+assign = name "=" value
+name = ~"[a-z]+"
+value = name
+`)
+	assert.NoError(t, err)
+
+	withRules, err := grammar.WithRules(map[string]Expression{
+		"value": types.NewOneOf("", []Expression{
+			types.NewLazyReference("name"),
+			types.NewRegex("", regexp2.MustCompile(`^[0-9]+`, regexp2.RE2)),
+		}),
+	})
+	assert.NoError(t, err)
+
+	tree, err := withRules.Parse("x = 42")
+	assert.NoError(t, err)
+	assert.Equal(t, "value", tree.Children[2].Expression.ExprName())
+
+	_, err = grammar.Parse("x = 42")
+	assert.Error(t, err)
+	_, err = grammar.Parse("x = y")
+	assert.NoError(t, err)
+}
+
+func Test_Grammar_ParameterizedRules(t *testing.T) {
+	grammar, err := NewGrammar(`
+decl = sep_by(identifier, ",") ":" sep_by(type, "|")
+sep_by(item, sep) = item (sep item)*
+identifier = ~"[a-z]+"
+type = ~"[A-Z][a-z]*"
+nested = "[" list(nested_item) "]"
+nested_item = identifier / nested
+list(x) = sep_by(x, ",")?
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("a,b,c:Int|Str")
+	assert.NoError(t, err)
+	assert.Equal(t, `sep_by(identifier, ",")`, tree.Children[0].Expression.ExprName())
+	assert.Equal(t, `sep_by(type, "|")`, tree.Children[2].Expression.ExprName())
+
+	_, err = grammar.ParseWithRule("nested", "[a,[b,[]],c]")
+	assert.NoError(t, err)
+
+	// each use is a separate expression
+	decl, _ := grammar.GetRule("decl")
+	assert.Contains(t, decl.String(), `sep_by(identifier, ",")`)
+	assert.Contains(t, decl.String(), `sep_by(type, "|")`)
+
+	grammar, err = NewGrammar(`
+entry = pair(identifier, number)
+pair(key, value) = key ":" ~> value
+identifier = ~"[a-z]+"
+number = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("a:b")
+	assert.ErrorContains(t, err, `expected "number" after the cut in rule "pair(identifier, number)"`)
+
+	_, err = NewGrammar(`
+start = sep_by(item)
+sep_by(item, sep) = item (sep item)*
+item = "a"
+`)
+	assert.ErrorContains(t, err, `parameterized rule "sep_by" expects 2 arguments, got 1`)
+
+	_, err = NewGrammar(`
+start = sep_by
+sep_by(item, sep) = item (sep item)*
+`)
+	assert.ErrorContains(t, err, `parameterized rule "sep_by" is referenced without arguments`)
+}
+
+func Test_Grammar_Default(t *testing.T) {
+	grammar, err := NewGrammar(`
+sep_by(item, sep) = item (sep item)*
+@start = list
+number = ~"[0-9]+"
+list = "[" sep_by(number, ",") "]"
+`)
+	assert.NoError(t, err)
+	tree, err := grammar.Parse("[1,2]")
+	assert.NoError(t, err)
+	assert.Equal(t, "list", tree.Expression.ExprName())
+
+	numberGrammar, err := grammar.Default("number")
+	assert.NoError(t, err)
+	_, err = numberGrammar.Parse("42")
+	assert.NoError(t, err)
+	// the original grammar is unchanged
+	_, err = grammar.Parse("42")
+	assert.Error(t, err)
+
+	_, err = grammar.Default("missing")
+	assert.ErrorContains(t, err, `no such rule "missing"`)
+	_, err = grammar.Default("sep_by")
+	assert.Error(t, err)
+
+	grammar, err = NewGrammar(`
+number = ~"[0-9]+"
+sum = number "+" number
+`)
+	assert.NoError(t, err)
+	_, err = grammar.Parse("1+2")
+	assert.Error(t, err)
+
+	_, err = NewGrammar(`
+@start = missing
+number = ~"[0-9]+"
+`)
+	assert.ErrorContains(t, err, `no such rule "missing"`)
+
+	_, err = NewGrammar(`
+@start = "a"
+number = ~"[0-9]+"
+`)
+	assert.ErrorContains(t, err, "@start should be a rule name")
+}
+
+func Test_Grammar_ParseTokens(t *testing.T) {
+	grammar, err := NewGrammar(`
+sum = term ("PLUS" term)*
+term = ~"[0-9]+" / call
+call = "IDENT" "LPAREN" sum "RPAREN"
+`)
+	assert.NoError(t, err)
+
+	// f(1 + 23)
+	tokens := []Token{
+		{Rule: "IDENT", Start: 0, End: 1, Text: "f"},
+		{Rule: "LPAREN", Start: 1, End: 2, Text: "("},
+		{Rule: "NUMBER", Start: 2, End: 3, Text: "1"},
+		{Rule: "PLUS", Start: 4, End: 5, Text: "+"},
+		{Rule: "NUMBER", Start: 6, End: 8, Text: "23"},
+		{Rule: "RPAREN", Start: 8, End: 9, Text: ")"},
+	}
+	tree, err := grammar.ParseTokens(tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, tree.Start)
+	assert.Equal(t, 6, tree.End)
+	assert.Equal(t, "f(1+23)", tree.Text)
+
+	call := tree.Children[0].Children[0]
+	assert.Equal(t, "call", call.Expression.ExprName())
+	inner := call.Children[2]
+	assert.Equal(t, "sum", inner.Expression.ExprName())
+	start, end := TokenSpan(tokens, inner)
+	assert.Equal(t, 2, start)
+	assert.Equal(t, 8, end)
+
+	// regexes match the whole text of a token
+	_, err = grammar.ParseTokens([]Token{{Rule: "NUMBER", Start: 0, End: 2, Text: "1a"}})
+	var parseFailed *ErrParseFailed
+	assert.ErrorAs(t, err, &parseFailed)
+	assert.Equal(t, 0, parseFailed.Position)
+	assert.ErrorContains(t, err, `token 0 NUMBER "1a"`)
+
+	// any match covering the whole text matches, not only the leftmost one
+	alternation, err := NewGrammar(`word = ~"a|ab"`)
+	assert.NoError(t, err)
+	_, err = alternation.ParseTokens([]Token{{Rule: "WORD", Start: 0, End: 2, Text: "ab"}})
+	assert.NoError(t, err)
+
+	_, err = grammar.ParseTokens(nil)
+	assert.ErrorContains(t, err, "the end of tokens")
+
+	_, err = grammar.ParseTokens(tokens[2:])
+	var incomplete *ErrIncompleteParseFailed
+	assert.ErrorAs(t, err, &incomplete)
+	assert.ErrorContains(t, err, `token 3 RPAREN ")"`)
+}
+
+func Test_Grammar_Lexer(t *testing.T) {
+	grammar, err := NewGrammar(`
+expr = IDENT ("=" NUMBER)?
+IDENT = ~"[a-z]+"
+NUMBER = ~"[0-9]+"
+KEYWORD = "let" / "in"
+OP = "==" / "="
+WS = ~"\s+"
+`)
+	assert.NoError(t, err)
+
+	lexer, err := grammar.Lexer("KEYWORD", "IDENT", "NUMBER", "OP", "WS")
+	assert.NoError(t, err)
+
+	tokens, err := lexer.Tokenize("let letter == 42 $# in")
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Rule: "KEYWORD", Start: 0, End: 3, Text: "let"},
+		{Rule: "WS", Start: 3, End: 4, Text: " "},
+		{Rule: "IDENT", Start: 4, End: 10, Text: "letter"},
+		{Rule: "WS", Start: 10, End: 11, Text: " "},
+		{Rule: "OP", Start: 11, End: 13, Text: "=="},
+		{Rule: "WS", Start: 13, End: 14, Text: " "},
+		{Rule: "NUMBER", Start: 14, End: 16, Text: "42"},
+		{Rule: "WS", Start: 16, End: 17, Text: " "},
+		{Rule: DefaultErrorTokenRule, Start: 17, End: 19, Text: "$#"},
+		{Rule: "WS", Start: 19, End: 20, Text: " "},
+		{Rule: "KEYWORD", Start: 20, End: 22, Text: "in"},
+	}, tokens)
+
+	// the first matching rule wins
+	tokens, err = lexer.WithFirstMatch(true).WithErrorRule("bad").Tokenize("letter==é")
+	assert.NoError(t, err)
+	assert.Equal(t, []Token{
+		{Rule: "KEYWORD", Start: 0, End: 3, Text: "let"},
+		{Rule: "IDENT", Start: 3, End: 6, Text: "ter"},
+		{Rule: "OP", Start: 6, End: 8, Text: "=="},
+		{Rule: "bad", Start: 8, End: 9, Text: "é"},
+	}, tokens)
+
+	tokens, err = lexer.Tokenize("")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, err = grammar.Lexer("IDENT", "missing")
+	assert.ErrorContains(t, err, `no such rule "missing"`)
+	_, err = grammar.Lexer()
+	assert.Error(t, err)
+}
+
+func Test_Grammar_Suggest(t *testing.T) {
+	grammar, err := NewGrammar(`
+query = "select" _ fields _ "from" _ table (_ "where" _ condition)?
+fields = "*" / field_list
+field_list = field ("," _ field)*
+field "field name" = ~"[a-z_]+"
+table = ~"[a-z_]+"
+condition = field _ ("=" / "!=") _ number
+number = ~"[0-9]+"
+_ = ~"\s*"
+`)
+	assert.NoError(t, err)
+
+	texts := func(suggestions []Suggestion) []string {
+		var rv []string
+		for _, s := range suggestions {
+			rv = append(rv, fmt.Sprintf("%s:%s", s.Kind, s.Text))
+		}
+		return rv
+	}
+
+	suggestions := grammar.Suggest("sel", 3)
+	assert.Equal(t, []string{"literal:select"}, texts(suggestions))
+	assert.Equal(t, "sel", suggestions[0].Partial)
+	assert.Equal(t, 0, suggestions[0].Start)
+
+	assert.Equal(t, []string{"literal:*", "category:field name"}, texts(grammar.Suggest("select ", 7)))
+
+	suggestions = grammar.Suggest("select a fr", 11)
+	assert.Contains(t, texts(suggestions), "literal:from")
+	assert.NotContains(t, texts(suggestions), "literal:where")
+	assert.Equal(t, "fr", suggestions[0].Partial)
+	assert.Equal(t, 9, suggestions[0].Start)
+
+	// the text after the cursor is ignored
+	assert.Equal(t, []string{"category:table"}, texts(grammar.Suggest("select a from t where", 15)))
+
+	assert.Equal(t,
+		[]string{"literal:!=", "literal:="},
+		texts(grammar.Suggest("select a from t where a ", 24)),
+	)
 }
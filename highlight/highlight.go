@@ -0,0 +1,198 @@
+// Package highlight classifies the text of a parse tree into spans of scopes,
+// and renders the spans as ANSI colored text or HTML.
+package highlight
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/b4fun/parsimonious-go/types"
+)
+
+// ScopeMap maps the rule names to the scope names, like "string" or "keyword.control".
+// For lexer tokens, the token rules are mapped instead.
+type ScopeMap map[string]string
+
+// Span is a classified span of the text.
+type Span struct {
+	// Start is the rune start index of the span.
+	Start int
+	// End is the rune end index of the span.
+	End int
+	// Text is the text of the span.
+	Text string
+	// Scopes are the scopes of the span from the outermost to the innermost, empty for unclassified text.
+	Scopes []string
+}
+
+// Scope returns the innermost scope of the span, empty for unclassified text.
+func (s Span) Scope() string {
+	if len(s.Scopes) == 0 {
+		return ""
+	}
+	return s.Scopes[len(s.Scopes)-1]
+}
+
+// Spans classifies the text parsed into the tree with the scopes of the rules.
+// The nested rules with scopes add inner scopes to their spans. The spans cover the whole text,
+// and the adjacent spans of the same scopes are merged.
+//
+// The positions of the tree are rune positions of the text, see TokenTreeSpans for the trees parsed in token mode.
+func Spans(text string, tree *types.Node, scopes ScopeMap) []Span {
+	b := &spansBuilder{runes: []rune(text), scopes: scopes}
+	b.visit(tree, nil)
+	return b.finish()
+}
+
+// TokenTreeSpans classifies the text tokenized into the tokens and parsed into the tree in token mode,
+// as Spans does. The token indexes of the tree are mapped back to the text with types.TokenSpan,
+// and the text between the tokens of a rule is classified with the scopes of the rule.
+func TokenTreeSpans(text string, tokens []types.Token, tree *types.Node, scopes ScopeMap) []Span {
+	b := &spansBuilder{runes: []rune(text), scopes: scopes, tokens: tokens}
+	b.visit(tree, nil)
+	return b.finish()
+}
+
+// TokenSpans classifies the text tokenized into the tokens with the scopes of the token rules.
+func TokenSpans(text string, tokens []types.Token, scopes ScopeMap) []Span {
+	b := &spansBuilder{runes: []rune(text), scopes: scopes}
+	for _, token := range tokens {
+		b.add(token.Start, nil)
+		b.add(token.End, b.scopesOf(token.Rule, nil))
+	}
+	return b.finish()
+}
+
+// Highlighter classifies texts with a grammar, or with a lexer when the text doesn't parse.
+type Highlighter struct {
+	grammar *types.Grammar
+	lexer   *types.Lexer
+	scopes  ScopeMap
+}
+
+// New creates a highlighter which parses texts with the default rule of the grammar.
+func New(grammar *types.Grammar, scopes ScopeMap) *Highlighter {
+	return &Highlighter{
+		grammar: grammar,
+		scopes:  scopes,
+	}
+}
+
+// WithLexer returns a copy of the highlighter, which falls back to the lexer when the text doesn't parse.
+func (h *Highlighter) WithLexer(lexer *types.Lexer) *Highlighter {
+	rv := *h
+	rv.lexer = lexer
+	return &rv
+}
+
+// Highlight classifies the text. When the text doesn't parse, the spans are classified
+// by the lexer, or the whole text is unclassified without a lexer, and the parse error is returned
+// along with the spans. The error of the lexer, if it fails too, is joined to the parse error.
+func (h *Highlighter) Highlight(text string, parseOpts ...types.ParseOption) ([]Span, error) {
+	tree, err := h.grammar.Parse(text, parseOpts...)
+	if err == nil {
+		return Spans(text, tree, h.scopes), nil
+	}
+	if h.lexer != nil {
+		tokens, lexErr := h.lexer.Tokenize(text, parseOpts...)
+		if lexErr != nil {
+			return TokenSpans(text, nil, h.scopes), errors.Join(err, lexErr)
+		}
+		return TokenSpans(text, tokens, h.scopes), err
+	}
+	return TokenSpans(text, nil, h.scopes), err
+}
+
+// spansBuilder builds the spans from the start of the text.
+type spansBuilder struct {
+	runes  []rune
+	scopes ScopeMap
+	spans  []Span
+	// pos is the end of the built spans.
+	pos int
+	// tokens are the tokens of the tree parsed in token mode, nil for a tree parsed from the text.
+	tokens []types.Token
+}
+
+// spanOf returns the rune span of the node in the text.
+func (b *spansBuilder) spanOf(node *types.Node) (int, int) {
+	if b.tokens == nil {
+		return node.Start, node.End
+	}
+	return types.TokenSpan(b.tokens, node)
+}
+
+// scopesOf returns the scopes inside the rule, which are the outer scopes with the scope of the rule if any.
+func (b *spansBuilder) scopesOf(ruleName string, outer []string) []string {
+	scope, ok := b.scopes[ruleName]
+	if !ok || ruleName == "" {
+		return outer
+	}
+	return append(outer[:len(outer):len(outer)], scope)
+}
+
+func (b *spansBuilder) visit(node *types.Node, outer []string) {
+	if node.Start == node.End {
+		return
+	}
+	scopes := b.scopesOf(node.Expression.ExprName(), outer)
+	start, end := b.spanOf(node)
+	// the text before the node, e.g. before the first token, is outside of it
+	b.add(start, outer)
+
+	members := make([]*types.Node, 0, len(node.Children)+len(node.Trivia))
+	members = append(members, node.Children...)
+	members = append(members, node.Trivia...)
+	sort.SliceStable(members, func(i, j int) bool {
+		return members[i].Start < members[j].Start
+	})
+	for _, member := range members {
+		memberStart, _ := b.spanOf(member)
+		if memberStart < b.pos {
+			// overlapping with the built spans, e.g. a lookahead
+			continue
+		}
+		b.add(memberStart, scopes)
+		b.visit(member, scopes)
+	}
+	b.add(end, scopes)
+}
+
+// add adds the span till end with the scopes if there's text before end,
+// merged into the last span of the same scopes.
+func (b *spansBuilder) add(end int, scopes []string) {
+	if end > len(b.runes) {
+		end = len(b.runes)
+	}
+	if end <= b.pos {
+		return
+	}
+
+	if n := len(b.spans); n > 0 && equalScopes(b.spans[n-1].Scopes, scopes) {
+		b.spans[n-1].End = end
+	} else {
+		b.spans = append(b.spans, Span{Start: b.pos, End: end, Scopes: scopes})
+	}
+	b.pos = end
+}
+
+// finish adds the unclassified span of the rest of the text, and sets the texts of the spans.
+func (b *spansBuilder) finish() []Span {
+	b.add(len(b.runes), nil)
+	for idx := range b.spans {
+		b.spans[idx].Text = string(b.runes[b.spans[idx].Start:b.spans[idx].End])
+	}
+	return b.spans
+}
+
+func equalScopes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for idx := range a {
+		if a[idx] != b[idx] {
+			return false
+		}
+	}
+	return true
+}
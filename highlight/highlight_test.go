@@ -0,0 +1,183 @@
+package highlight
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/b4fun/parsimonious-go/internal/bootstrap"
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+type span struct {
+	text   string
+	scopes string
+}
+
+func describe(spans []Span) []span {
+	var rv []span
+	for _, s := range spans {
+		rv = append(rv, span{s.Text, strings.Join(s.Scopes, ",")})
+	}
+	return rv
+}
+
+var configGrammar = `
+config = entry*
+entry = key ws? "=" ws? value ws? comment? ~"\n?"
+key = ~"[a-z_]+"
+value = string / number
+string = "\"" (escape / ~r'[^"\\]+')* "\""
+escape = ~r"\\."
+number = ~"[0-9]+"
+comment = ~"#[^\n]*"
+ws = ~"[ \t]+"
+`
+
+var configScopes = ScopeMap{
+	"key":     "variable",
+	"string":  "string",
+	"escape":  "string.escape",
+	"number":  "number",
+	"comment": "comment",
+	"error":   "error",
+}
+
+func Test_Spans(t *testing.T) {
+	grammar, err := bootstrap.NewGrammar(configGrammar)
+	assert.NoError(t, err)
+
+	text := "name = \"a\\nb\" # hi\nport = 80\n"
+	tree, err := grammar.Parse(text)
+	assert.NoError(t, err)
+	spans := Spans(text, tree, configScopes)
+	assert.Equal(t, []span{
+		{"name", "variable"},
+		{" = ", ""},
+		{`"a`, "string"},
+		{`\n`, "string,string.escape"},
+		{`b"`, "string"},
+		{" ", ""},
+		{"# hi", "comment"},
+		{"\n", ""},
+		{"port", "variable"},
+		{" = ", ""},
+		{"80", "number"},
+		{"\n", ""},
+	}, describe(spans))
+	assert.Equal(t, 7, spans[2].Start)
+	assert.Equal(t, 9, spans[2].End)
+	assert.Equal(t, "string.escape", spans[3].Scope())
+	assert.Equal(t, "", spans[1].Scope())
+
+	// the positions are rune positions
+	text = "ключ = 1\n"
+	grammar, err = bootstrap.NewGrammar(`
+config = key " = " number "\n"
+key = ~"[^ ]+"
+number = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+	tree, err = grammar.Parse(text)
+	assert.NoError(t, err)
+	spans = Spans(text, tree, configScopes)
+	assert.Equal(t, []span{{"ключ", "variable"}, {" = ", ""}, {"1", "number"}, {"\n", ""}}, describe(spans))
+	assert.Equal(t, 4, spans[0].End)
+	assert.Equal(t, 7, spans[2].Start)
+}
+
+func Test_TokenTreeSpans(t *testing.T) {
+	grammar, err := bootstrap.NewGrammar(`
+assignment = "IDENT" "EQ" value
+value = "NUMBER" / "STRING"
+`)
+	assert.NoError(t, err)
+
+	text := " x = 42 "
+	tokens := []types.Token{
+		{Rule: "IDENT", Start: 1, End: 2, Text: "x"},
+		{Rule: "EQ", Start: 3, End: 4, Text: "="},
+		{Rule: "NUMBER", Start: 5, End: 7, Text: "42"},
+	}
+	tree, err := grammar.ParseTokens(tokens)
+	assert.NoError(t, err)
+
+	scopes := ScopeMap{"assignment": "meta.assignment", "value": "constant"}
+	assert.Equal(t, []span{
+		{" ", ""},
+		{"x = ", "meta.assignment"},
+		{"42", "meta.assignment,constant"},
+		{" ", ""},
+	}, describe(TokenTreeSpans(text, tokens, tree, scopes)))
+}
+
+func Test_Highlighter(t *testing.T) {
+	grammar, err := bootstrap.NewGrammar(configGrammar)
+	assert.NoError(t, err)
+
+	spans, err := New(grammar, configScopes).Highlight("port = 80\n")
+	assert.NoError(t, err)
+	assert.Equal(t, []span{
+		{"port", "variable"},
+		{" = ", ""},
+		{"80", "number"},
+		{"\n", ""},
+	}, describe(spans))
+
+	// falls back to the lexer when the text doesn't parse
+	lexer, err := grammar.Lexer("key", "number", "comment", "ws")
+	assert.NoError(t, err)
+	spans, err = New(grammar, configScopes).WithLexer(lexer).Highlight("port = 80 <")
+	assert.Error(t, err)
+	assert.Equal(t, []span{
+		{"port", "variable"},
+		{" ", ""},
+		{"=", "error"},
+		{" ", ""},
+		{"80", "number"},
+		{" ", ""},
+		{"<", "error"},
+	}, describe(spans))
+
+	spans, err = New(grammar, configScopes).Highlight("port <")
+	assert.Error(t, err)
+	assert.Equal(t, []span{{"port <", ""}}, describe(spans))
+}
+
+func Test_ANSI(t *testing.T) {
+	spans := []Span{
+		{Text: "name", Scopes: []string{"variable"}},
+		{Text: " = "},
+		{Text: `"a`, Scopes: []string{"string"}},
+		{Text: `\n`, Scopes: []string{"string", "string.escape"}},
+		{Text: "?", Scopes: []string{"unknown"}},
+	}
+
+	// the dotted scopes without colors are colored by their parents, and unknown scopes aren't colored
+	assert.Equal(t,
+		"\x1b[37mname\x1b[0m = \x1b[32m\"a\x1b[0m\x1b[32m\\n\x1b[0m?",
+		ANSI(spans, DefaultANSIColors),
+	)
+	assert.Equal(t,
+		"\x1b[37mname\x1b[0m = \x1b[32m\"a\x1b[0m\x1b[1;32m\\n\x1b[0m?",
+		ANSI(spans, map[string]string{"variable": "37", "string": "32", "string.escape": "1;32"}),
+	)
+	assert.Equal(t, `name = "a\n?`, ANSI(spans, nil))
+}
+
+func Test_HTML(t *testing.T) {
+	spans := []Span{
+		{Text: "name", Scopes: []string{"variable"}},
+		{Text: " < "},
+		{Text: `"a&b"`, Scopes: []string{"string", "string.escape"}},
+		{Text: "x", Scopes: []string{`bad"><script>`}},
+	}
+
+	assert.Equal(t,
+		`<span class="hl-variable">name</span> &lt; `+
+			`<span class="hl-string hl-string-escape">&#34;a&amp;b&#34;</span>`+
+			`<span class="hl-bad&#34;&gt;&lt;script&gt;">x</span>`,
+		HTML(spans, "hl-"),
+	)
+	assert.Equal(t, `<span class="&lt;variable">name</span>`, HTML(spans[:1], "<"))
+}
@@ -0,0 +1,88 @@
+package highlight
+
+import (
+	"html"
+	"strings"
+)
+
+// DefaultANSIColors are the ANSI SGR parameters of the common scopes.
+var DefaultANSIColors = map[string]string{
+	"comment":  "90",
+	"keyword":  "35",
+	"string":   "32",
+	"number":   "36",
+	"constant": "36",
+	"operator": "33",
+	"function": "34",
+	"type":     "34;1",
+	"variable": "37",
+	"error":    "31;4",
+}
+
+// ANSI renders the spans as text colored with ANSI escape sequences.
+//
+// The colors map the scopes to the SGR parameters, like "1;34" for bold blue.
+// A span is colored by its innermost scope with a color. A dotted scope without a color
+// is colored by its parent scope, e.g. "string.escape" by "string".
+func ANSI(spans []Span, colors map[string]string) string {
+	var sb strings.Builder
+	for _, span := range spans {
+		color := ansiColor(span, colors)
+		if color == "" {
+			sb.WriteString(span.Text)
+			continue
+		}
+		sb.WriteString("\x1b[")
+		sb.WriteString(color)
+		sb.WriteString("m")
+		sb.WriteString(span.Text)
+		sb.WriteString("\x1b[0m")
+	}
+	return sb.String()
+}
+
+func ansiColor(span Span, colors map[string]string) string {
+	for idx := len(span.Scopes) - 1; idx >= 0; idx-- {
+		for scope := span.Scopes[idx]; scope != ""; scope = parentScope(scope) {
+			if color, ok := colors[scope]; ok {
+				return color
+			}
+		}
+	}
+	return ""
+}
+
+// parentScope returns the parent of the dotted scope, empty for a top level scope.
+func parentScope(scope string) string {
+	idx := strings.LastIndex(scope, ".")
+	if idx < 0 {
+		return ""
+	}
+	return scope[:idx]
+}
+
+// HTML renders the spans as HTML-escaped text, of which classified spans are wrapped in span elements.
+//
+// The elements have a CSS class for each of the scopes from the outermost, prefixed by classPrefix,
+// with the dots replaced by dashes, e.g. "hl-string hl-string-escape" for the scopes "string" and "string.escape".
+func HTML(spans []Span, classPrefix string) string {
+	var sb strings.Builder
+	for _, span := range spans {
+		text := html.EscapeString(span.Text)
+		if len(span.Scopes) == 0 {
+			sb.WriteString(text)
+			continue
+		}
+
+		classes := make([]string, len(span.Scopes))
+		for idx, scope := range span.Scopes {
+			classes[idx] = classPrefix + strings.ReplaceAll(scope, ".", "-")
+		}
+		sb.WriteString(`<span class="`)
+		sb.WriteString(html.EscapeString(strings.Join(classes, " ")))
+		sb.WriteString(`">`)
+		sb.WriteString(text)
+		sb.WriteString("</span>")
+	}
+	return sb.String()
+}
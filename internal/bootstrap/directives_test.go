@@ -0,0 +1,79 @@
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewGrammar_InvalidDirectives(t *testing.T) {
+	cases := []struct {
+		name    string
+		text    string
+		line    int
+		message string
+	}{
+		{
+			name: "missing rule",
+			text: `
+number = ~"[0-9]+"
+@start = missing
+`,
+			line:    3,
+			message: `no such rule "missing"`,
+		},
+		{
+			name: "parameterized rule",
+			text: `
+number = ~"[0-9]+"
+list(item) = item+
+@start = list
+`,
+			line:    4,
+			message: `parameterized rule "list" can't be started from`,
+		},
+		{
+			name: "not a rule name",
+			text: `
+@start = "a"
+number = ~"[0-9]+"
+`,
+			line:    2,
+			message: "@start should be a rule name",
+		},
+		{
+			name: "duplicate",
+			text: `
+@start = number
+number = ~"[0-9]+"
+@start = number
+`,
+			line:    4,
+			message: "@start is defined more than once",
+		},
+		{
+			name: "duplicate skip",
+			text: `
+@skip = " "*
+number = ~"[0-9]+"
+@skip = ~"[ \t]*"
+`,
+			line:    4,
+			message: "@skip is defined more than once",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewGrammar(c.text)
+			var invalidGrammar *types.ErrInvalidGrammar
+			if assert.ErrorAs(t, err, &invalidGrammar) {
+				line, column := invalidGrammar.LineAndColumn()
+				assert.Equal(t, c.line, line)
+				assert.Equal(t, 1, column)
+				assert.ErrorContains(t, err, c.message)
+			}
+		})
+	}
+}
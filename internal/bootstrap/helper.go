@@ -6,15 +6,42 @@ import (
 	"github.com/b4fun/parsimonious-go/types"
 )
 
-func asGrammar(v any, err error) (*types.Grammar, error) {
+// failureLabel is the label and message of a labeled failure in rules.
+type failureLabel struct {
+	label   string
+	message string
+}
+
+// directive is a grammar directive in the form of @name = expression.
+type directive struct {
+	name       string
+	expression types.Expression
+	// position is the rune position of the directive in the grammar text.
+	position int
+}
+
+// namedTerm is a labeled member of a sequence or choice in the form of name:term.
+type namedTerm struct {
+	name       string
+	expression types.Expression
+}
+
+// grammarDefinitions are the rules and directives defined in a grammar text.
+type grammarDefinitions struct {
+	rules      []types.Expression
+	directives []directive
+	imports    []importDirective
+}
+
+func asGrammarDefinitions(v any, err error) (grammarDefinitions, error) {
 	if err != nil {
-		return nil, err
+		return grammarDefinitions{}, err
 	}
 
-	if result, ok := v.(*types.Grammar); ok {
+	if result, ok := v.(grammarDefinitions); ok {
 		return result, nil
 	}
-	return nil, fmt.Errorf("expected *Grammar, got %T", v)
+	return grammarDefinitions{}, fmt.Errorf("expected grammar definitions, got %T", v)
 }
 
 func assertNodeToHaveChildrenCount(node *types.Node, children []any, count int) error {
@@ -48,11 +75,46 @@ func shouldCastAsExpressionWithType[T types.Expression](v any) (T, error) {
 func shouldCastAsExpression(v any) (types.Expression, error) {
 	if expression, ok := v.(types.Expression); ok {
 		return expression, nil
+	} else if named, ok := v.(namedTerm); ok {
+		return nil, fmt.Errorf("label %q should be placed on a member of a sequence or choice", named.name)
 	} else {
 		return nil, fmt.Errorf("expected Expression, got %#v", v)
 	}
 }
 
+// shouldCastAsMembers casts the terms of a sequence or choice as the members and their labels.
+// The labels are nil if none of the members is labeled.
+func shouldCastAsMembers(first any, others any) ([]types.Expression, []string, error) {
+	otherTerms, ok := others.([]any)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected []Expression, got %#v", others)
+	}
+
+	terms := append([]any{first}, otherTerms...)
+	members := make([]types.Expression, len(terms))
+	labels := make([]string, len(terms))
+	labeled := false
+	for idx, term := range terms {
+		if named, ok := term.(namedTerm); ok {
+			members[idx] = named.expression
+			labels[idx] = named.name
+			labeled = true
+			continue
+		}
+
+		expression, err := shouldCastAsExpression(term)
+		if err != nil {
+			return nil, nil, err
+		}
+		members[idx] = expression
+	}
+	if !labeled {
+		labels = nil
+	}
+
+	return members, labels, nil
+}
+
 func shouldCastAsExpressions(v any) ([]types.Expression, error) {
 	exprs, ok := v.([]any)
 	if !ok {
@@ -0,0 +1,154 @@
+package bootstrap
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+
+	"github.com/b4fun/parsimonious-go/types"
+)
+
+// importDirective is an @import directive in the form of @import "path" as namespace.
+type importDirective struct {
+	path      string
+	namespace string
+	// position is the rune position of the directive in the grammar text.
+	position int
+}
+
+// grammarLoader loads a grammar text and the grammars imported by it.
+type grammarLoader struct {
+	fsys      fs.FS
+	parseOpts []types.ParseOption
+	// file is the path of the grammar being loaded, empty for the grammar text given to NewGrammar.
+	file string
+	// loading are the paths of the grammars being loaded, from the outermost import.
+	loading []string
+}
+
+// load parses the grammar text and creates the grammar from it.
+// The invalid definitions are reported with the position in the text.
+func (l *grammarLoader) load(text string) (*types.Grammar, error) {
+	definitions, err := l.parse(text)
+	if err != nil {
+		return nil, err
+	}
+	grammar, err := buildGrammar(definitions, nil, l)
+	if err != nil {
+		return nil, l.locateError(text, err)
+	}
+	return grammar, nil
+}
+
+// parse parses the grammar text into the definitions.
+func (l *grammarLoader) parse(text string) (grammarDefinitions, error) {
+	tree, err := ParsimoniousGrammar.Parse(text, l.parseOpts...)
+	if err != nil {
+		if l.file != "" {
+			return grammarDefinitions{}, fmt.Errorf("%s: parse grammar: %w", l.file, err)
+		}
+		return grammarDefinitions{}, fmt.Errorf("parse grammar: %w", err)
+	}
+
+	const debugRuleVisitor = false
+	mux := createRuleVisitor(debugRuleVisitor)
+	definitions, err := asGrammarDefinitions(mux.Visit(tree))
+	if err != nil {
+		return grammarDefinitions{}, l.locateError(text, err)
+	}
+	return definitions, nil
+}
+
+// locateError sets the grammar text of the invalid definition error.
+func (l *grammarLoader) locateError(text string, err error) error {
+	var invalidGrammar *types.ErrInvalidGrammar
+	// errors from the imported grammars have been located in their files
+	if errors.As(err, &invalidGrammar) && invalidGrammar.Text == "" {
+		invalidGrammar.File = l.file
+		invalidGrammar.Text = text
+	}
+	return err
+}
+
+// importGrammar loads the grammar imported by the directive.
+// The path is relative to the importing grammar file, or to the root of the file system.
+func (l *grammarLoader) importGrammar(d importDirective) (*types.Grammar, error) {
+	if l.fsys == nil {
+		return nil, &types.ErrInvalidGrammar{
+			Position: d.position,
+			Err:      fmt.Errorf("import %q: no file system to import from, see ParseWithFS", d.path),
+		}
+	}
+
+	file := path.Join(path.Dir(l.file), d.path)
+	for idx, loading := range l.loading {
+		if loading == file {
+			cycle := append(append([]string{}, l.loading[idx:]...), file)
+			return nil, &types.ErrInvalidGrammar{
+				Position: d.position,
+				Err:      fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> ")),
+			}
+		}
+	}
+
+	text, err := fs.ReadFile(l.fsys, file)
+	if err != nil {
+		return nil, &types.ErrInvalidGrammar{
+			Position: d.position,
+			Err:      fmt.Errorf("import %q: %w", d.path, err),
+		}
+	}
+
+	imported := &grammarLoader{
+		fsys:      l.fsys,
+		parseOpts: l.parseOpts,
+		file:      file,
+		loading:   append(append([]string{}, l.loading...), file),
+	}
+	grammar, err := imported.load(string(text))
+	if err != nil {
+		var invalidGrammar *types.ErrInvalidGrammar
+		if errors.As(err, &invalidGrammar) {
+			return nil, err
+		}
+		// the errors without a position in the imported grammar, e.g. unresolved references,
+		// are located at the directive importing it
+		return nil, &types.ErrInvalidGrammar{
+			Position: d.position,
+			Err:      fmt.Errorf("import %q: %w", d.path, err),
+		}
+	}
+	return grammar, nil
+}
+
+// addImportedRules adds the rules of the imported grammar to rules, with names prefixed by the namespace.
+// The built-in rules aren't imported, as every grammar has its own. The imported rules are copied,
+// so the imported grammar is unchanged.
+func addImportedRules(rules map[string]types.Expression, namespace string, imported *types.Grammar) error {
+	var names []string
+	for _, name := range imported.RuleNames() {
+		if rule, _ := imported.GetRule(name); !isBuiltinRule(name, rule) {
+			names = append(names, name)
+		}
+	}
+
+	for name, rule := range imported.NamespacedRules(namespace, names) {
+		if _, exists := rules[name]; exists {
+			return fmt.Errorf("rule %q is imported more than once", name)
+		}
+		rules[name] = rule
+	}
+	return nil
+}
+
+// isBuiltinRule reports if the rule of the name is a built-in rule, rather than a rule defined by the grammar.
+func isBuiltinRule(name string, rule types.Expression) bool {
+	for _, builtin := range createBuiltinRules() {
+		if builtin.ExprName() == name && fmt.Sprintf("%T", builtin) == fmt.Sprintf("%T", rule) {
+			return true
+		}
+	}
+	return false
+}
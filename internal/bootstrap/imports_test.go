@@ -0,0 +1,87 @@
+package bootstrap
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_addImportedRules(t *testing.T) {
+	imported, err := NewGrammar(`
+lines = line+ EOF
+line = word "\n"
+word = name
+name = ~"[a-z]+"
+`)
+	assert.NoError(t, err)
+
+	rules := make(map[string]types.Expression)
+	assert.NoError(t, addImportedRules(rules, "t", imported))
+
+	// the built-in rules aren't imported
+	_, ok := rules["t.EOF"]
+	assert.False(t, ok)
+	_, ok = rules["t.lines"]
+	assert.True(t, ok)
+
+	// the imported grammar is unchanged
+	for _, name := range []string{"lines", "line", "EOF"} {
+		rule, _ := imported.GetRule(name)
+		assert.Equal(t, name, rule.ExprName())
+	}
+	word, _ := imported.GetRule("word")
+	assert.Equal(t, "name", word.ExprName())
+
+	assert.Error(t, addImportedRules(rules, "t", imported))
+}
+
+func Test_NewGrammar_ImportAliases(t *testing.T) {
+	fsys := fstest.MapFS{
+		"words.peg": {Data: []byte(`
+word = name
+name = ~"[a-z]+"
+`)},
+	}
+
+	grammar, err := NewGrammar(`
+@import "words.peg" as w
+sentence = w.word " " w.name EOF
+`, types.ParseWithFS(fsys))
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("hello world")
+	assert.NoError(t, err)
+	assert.Equal(t, "w.name", tree.Children[0].Expression.ExprName())
+	assert.Equal(t, "w.name", tree.Children[2].Expression.ExprName())
+	assert.Equal(t, "EOF", tree.Children[3].Expression.ExprName())
+}
+
+func Test_NewGrammar_ImportUnresolvedReference(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/q.peg": {Data: []byte(`
+word = name
+name = missing
+`)},
+		"lib/p.peg": {Data: []byte(`
+p = "p"
+@import "q.peg" as q
+`)},
+	}
+
+	// the unresolved reference is reported at the import of the file
+	_, err := NewGrammar(`
+s = p.p
+@import "lib/p.peg" as p
+`, types.ParseWithFS(fsys))
+	var invalidGrammar *types.ErrInvalidGrammar
+	if assert.ErrorAs(t, err, &invalidGrammar) {
+		assert.Equal(t, "lib/p.peg", invalidGrammar.File)
+		line, column := invalidGrammar.LineAndColumn()
+		assert.Equal(t, 3, line)
+		assert.Equal(t, 1, column)
+		assert.ErrorContains(t, err, `import "q.peg"`)
+		assert.ErrorContains(t, err, `lazy reference "missing" is not resolved`)
+	}
+}
@@ -12,10 +12,36 @@ const ruleSyntax = `
 # Ignored things (represented by _) are typically hung off the end of the
 # leafmost kinds of nodes. Literals like "/" count as leaves.
 
-rules = _ rule*
-rule = label equals expression
+rules = _ definition*
+definition = import_directive / directive / rule
+rule = annotations label rule_params display_name equals expression
 equals = "=" _
-literal = spaceless_literal _
+
+# Directives configure the grammar, e.g. @skip = ~r"\s+" / comment
+directive = "@" label equals expression
+
+# Imports bring the rules of another grammar file under a namespace,
+# e.g. @import "common.peg" as c makes the rules available as c.identifier
+import_directive = "@import" _ literal ~r"as\b" _ label
+
+# Annotations change how a rule is matched and built, e.g. @token number = ...
+annotations = annotation*
+annotation = ~r"@(token|inline|nomemo)\b" _
+
+# A rule can declare parameters, which are bound to the arguments of each use,
+# e.g. sep_by(item, sep) = item (sep item)* used as sep_by(identifier, ",")
+rule_params = rule_param_list?
+rule_param_list = "(" _ label rule_param* ")" _
+rule_param = "," _ label
+
+# A rule can declare a display name for error messages: number "a number" = ...
+# It's a plain string, without the flags of literals.
+display_name = display_name_text?
+display_name_text = spaceless_literal _
+rule_head_rest = rule_params display_name equals
+literal = spaceless_literal literal_flags _
+# A literal followed by i matches regardless of case, e.g. "select"i
+literal_flags = ~r"(i\b)?"
 
 # FIXME(hbc): invalid regex
 # spaceless_literal = ~"r?\"[^\"\\\\]*(?:\\\\.[^\"\\\\]*)*\""is /
@@ -27,17 +53,56 @@ ored = term or_term+
 sequence = term term+
 not_term = "!" term _
 lookahead_term = "&" term _
-term = not_term / lookahead_term / quantified / atom
+term = not_term / lookahead_term / named_term / labeled / quantified / atom / cut
+labeled = labeled_term "^" _ failure_label
+labeled_term = quantified / atom
 quantified = atom quantifier
-atom = reference / literal / regex / parenthesized
+atom = call / reference / literal / regex / char_class / any_char / parenthesized / predicate / matcher / precedence
 regex = "~" spaceless_literal ~"[ilmsuxa]*"i _
 parenthesized = "(" _ expression ")" _
 quantifier = ~r"[*+?]|\{\d*,\d+\}|\{\d+,\d*\}|\{\d+\}" _
-reference = label !equals
-
-# A subsequent equal sign is the only thing that distinguishes a label
-# (which begins a new rule) from a reference (which is just a pointer to a
-# rule defined somewhere else):
+reference = reference_name !rule_head_rest
+reference_name = ~"[a-zA-Z_][a-zA-Z_0-9]*(?:\.[a-zA-Z_][a-zA-Z_0-9]*)*(?![\"'])" _
+
+# A use of a parameterized rule passes the arguments right after the name
+call = call_name "(" _ expression call_argument* ")" _ !rule_head_rest
+call_name = ~"[a-zA-Z_][a-zA-Z_0-9]*(?:\.[a-zA-Z_][a-zA-Z_0-9]*)*(?=\()"
+call_argument = "," _ expression
+
+# Character classes like [a-z_] or [^\p{L}] and "." match a single character,
+# and the built-in EOF rule matches the end of input:
+char_class = ~r"\[\^?(?:\\.|[^\]\\])+\]" _
+any_char = "." _
+
+# Members of sequences and choices can be labeled to fetch the matched
+# children by name, e.g. assign = name:identifier "=" value:expr
+named_term = label ":" _ term
+
+# A cut commits the sequence to the current match, the failure of the rest
+# of the sequence is reported as an error instead of backtracking:
+cut = "~>" _
+
+# A failure label throws a labeled failure when the term doesn't match,
+# in the forms of term^label, term^"message" or term^label:"message":
+failure_label = failure_label_with_message / label / literal
+failure_label_with_message = label ":" _ literal
+
+# Go functions registered with the grammar can be called as a predicate
+# (&{name} or !{name}) or as a matcher (@name):
+predicate = ~"[&!]" "{" _ label "}" _
+matcher = !annotation !import_directive "@" label !equals
+
+# Operators of a precedence expression are listed in rows from the lowest
+# precedence to the highest:
+precedence = "%precedence" _ "(" _ term ")" _ "{" _ operator_row* "}" _
+operator_row = operator_kind ":" _ operator_term+
+operator_kind = ~r"(left|right|prefix|postfix)\b" _
+operator_term = !operator_row_start term
+operator_row_start = operator_kind ":"
+
+# A subsequent equal sign, optionally preceded by a display name, is the only
+# thing that distinguishes a label (which begins a new rule) from a reference
+# (which is just a pointer to a rule defined somewhere else):
 label = ~"[a-zA-Z_][a-zA-Z_0-9]*(?![\"'])" _
 
 # _ = ~"\\s*(?:#[^\\r\\n]*)?\\s*"
@@ -65,7 +130,8 @@ var ParsimoniousGrammar *types.Grammar
 func initParsimoniousGrammar() (*types.Grammar, error) {
 	const debug = false
 
-	mux := createRuleVisitor(debug, []types.Expression{spacelessLiteral})
+	customRules := []types.Expression{spacelessLiteral}
+	mux := createRuleVisitor(debug)
 	bootstrapTree, err := types.ParseWithExpression(
 		createBootstrapRules(),
 		ruleSyntax,
@@ -75,7 +141,11 @@ func initParsimoniousGrammar() (*types.Grammar, error) {
 		return nil, fmt.Errorf("parse bootstrap grammar: %w", err)
 	}
 
-	bootstrapGrammar, err := asGrammar(mux.Visit(bootstrapTree))
+	bootstrapDefinitions, err := asGrammarDefinitions(mux.Visit(bootstrapTree))
+	if err != nil {
+		return nil, fmt.Errorf("visit bootstrap grammar: %w", err)
+	}
+	bootstrapGrammar, err := buildGrammar(bootstrapDefinitions, customRules, nil)
 	if err != nil {
 		return nil, fmt.Errorf("visit bootstrap grammar: %w", err)
 	}
@@ -85,7 +155,11 @@ func initParsimoniousGrammar() (*types.Grammar, error) {
 		return nil, fmt.Errorf("parse parsimonious grammar: %w", err)
 	}
 
-	result, err := asGrammar(mux.Visit(tree))
+	definitions, err := asGrammarDefinitions(mux.Visit(tree))
+	if err != nil {
+		return nil, fmt.Errorf("visit parsimonious grammar: %w", err)
+	}
+	result, err := buildGrammar(definitions, customRules, nil)
 	if err != nil {
 		return nil, fmt.Errorf("visit parsimonious grammar: %w", err)
 	}
@@ -99,15 +173,37 @@ func init() {
 	if err != nil {
 		panic(fmt.Errorf("init parsimonious grammar: %w", err))
 	}
+
+	types.RegisterRulesParser(parseRules)
 }
 
+// NewGrammar creates a grammar from the grammar text.
+// The grammars imported by @import directives are loaded from the file system set by ParseWithFS.
 func NewGrammar(input string, parseOpts ...types.ParseOption) (*types.Grammar, error) {
-	tree, err := ParsimoniousGrammar.Parse(input, parseOpts...)
+	loader := &grammarLoader{
+		fsys:      types.ImportFS(parseOpts...),
+		parseOpts: parseOpts,
+	}
+	return loader.load(input)
+}
+
+// parseRules parses the rules of the grammar text for Grammar.Extend, the references are left unresolved.
+func parseRules(text string) (map[string]types.Expression, error) {
+	loader := &grammarLoader{}
+	definitions, err := loader.parse(text)
 	if err != nil {
-		return nil, fmt.Errorf("parse grammar: %w", err)
+		return nil, err
+	}
+	if len(definitions.directives) > 0 || len(definitions.imports) > 0 {
+		return nil, fmt.Errorf("directives are not supported in extending a grammar")
+	}
+	if len(definitions.rules) == 0 {
+		return nil, fmt.Errorf("no rules to extend")
 	}
 
-	const debugRuleVisitor = false
-	mux := createRuleVisitor(debugRuleVisitor, nil)
-	return asGrammar(mux.Visit(tree))
+	rules := make(map[string]types.Expression, len(definitions.rules))
+	for _, rule := range definitions.rules {
+		rules[rule.ExprName()] = rule
+	}
+	return rules, nil
 }
@@ -160,11 +160,19 @@ func createBootstrapRules() types.Expression {
 	return rules
 }
 
-// createRuleVisitor creates a node visitor for the parsimonious grammar rules.
-func createRuleVisitor(
-	debug bool,
-	customRules []types.Expression,
-) *nodes.NodeVisitorMux {
+// createBuiltinRules returns the built-in rules, which can be referenced without being defined.
+func createBuiltinRules() []types.Expression {
+	return []types.Expression{
+		types.NewIndent(),
+		types.NewDedent(),
+		types.NewSamedent(),
+		types.NewEOF(),
+	}
+}
+
+// createRuleVisitor creates a node visitor for the parsimonious grammar rules,
+// which visits the rules into grammarDefinitions.
+func createRuleVisitor(debug bool) *nodes.NodeVisitorMux {
 	debugf := func(s string, args ...any) {
 		if debug {
 			fmt.Printf("[rule visitor] "+s, args...)
@@ -275,42 +283,191 @@ func createRuleVisitor(
 	})
 
 	visitRule := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
-		if err := assertNodeToHaveChildrenCount(node, children, 3); err != nil {
+		// rules of the bootstrap grammar have neither annotations, parameters nor display name
+		var annotations types.Annotations
+		var params []string
+		var displayName string
+		labelChild := children[0]
+		if len(children) == 6 {
+			var ok bool
+			annotations, ok = children[0].(types.Annotations)
+			if !ok {
+				return nil, fmt.Errorf("rule: expected annotations, got %#v", children[0])
+			}
+			labelChild = children[1]
+			params, ok = children[2].([]string)
+			if !ok {
+				return nil, fmt.Errorf("rule: expected parameters, got %#v", children[2])
+			}
+			displayName, ok = children[3].(string)
+			if !ok {
+				return nil, fmt.Errorf("rule: expected display name, got %#v", children[3])
+			}
+		} else if err := assertNodeToHaveChildrenCount(node, children, 3); err != nil {
 			return nil, err
 		}
 
-		label, err := shouldCastAsNode(children[0])
+		label, err := shouldCastAsNode(labelChild)
 		if err != nil {
 			return nil, fmt.Errorf("rule: %w", err)
 		}
-		expression, err := shouldCastAsExpression(children[2])
+		expression, err := shouldCastAsExpression(children[len(children)-1])
 		if err != nil {
 			return nil, fmt.Errorf("rule: %w", err)
 		}
 
+		if _, ok := expression.(*types.LazyReference); ok && (displayName != "" || annotations != 0) {
+			// the reference of an alias rule is replaced by the referenced rule on resolving,
+			// the display name and the annotations of the alias go with a sequence wrapping it
+			expression = types.NewSequence("", []types.Expression{expression})
+		}
+		if params != nil {
+			expression = types.NewRuleTemplate("", params, expression)
+		}
+
 		debugf("setting rule name %q to %s\n", label.Text, expression)
 		expression.SetExprName(label.Text)
+		if err := types.NameInlineOperations(expression); err != nil {
+			return nil, &types.ErrInvalidGrammar{Position: node.Start, Err: err}
+		}
+		if displayName != "" {
+			expression.SetDisplayName(displayName)
+		}
+		if annotations != 0 {
+			expression.SetAnnotations(annotations)
+		}
 
 		return expression, nil
 	})
 
-	visitSequence := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+	visitRuleParams := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if len(children) == 0 {
+			return []string(nil), nil
+		}
+
+		return children[0], nil
+	})
+
+	visitRuleParamList := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 6); err != nil {
+			return nil, err
+		}
+
+		labels := []any{children[2]}
+		if rest, ok := children[3].([]any); ok {
+			labels = append(labels, rest...)
+		}
+		var params []string
+		seen := make(map[string]bool)
+		for _, v := range labels {
+			label, err := shouldCastAsNode(v)
+			if err != nil {
+				return nil, fmt.Errorf("rule_param_list: %w", err)
+			}
+			if seen[label.Text] {
+				return nil, &types.ErrInvalidGrammar{
+					Position: label.Start,
+					Err:      fmt.Errorf("duplicated parameter %q", label.Text),
+				}
+			}
+			seen[label.Text] = true
+			params = append(params, label.Text)
+		}
+
+		return params, nil
+	})
+
+	visitAnnotations := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		var annotations types.Annotations
+		for _, child := range children {
+			annotation, ok := child.(types.Annotations)
+			if !ok {
+				return nil, fmt.Errorf("annotations: expected annotation, got %#v", child)
+			}
+			annotations |= annotation
+		}
+
+		return annotations, nil
+	})
+
+	visitAnnotation := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
 		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
 			return nil, err
 		}
 
-		term, err := shouldCastAsExpression(children[0])
+		name, err := shouldCastAsNode(children[0])
 		if err != nil {
-			return nil, fmt.Errorf("sequence: %w", err)
+			return nil, fmt.Errorf("annotation: %w", err)
+		}
+		annotation, ok := types.ParseAnnotation(strings.TrimPrefix(name.Text, "@"))
+		if !ok {
+			return nil, fmt.Errorf("annotation: unknown annotation %q", name.Text)
+		}
+
+		return annotation, nil
+	})
+
+	visitDirective := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
+			return nil, err
+		}
+
+		label, err := shouldCastAsNode(children[1])
+		if err != nil {
+			return nil, fmt.Errorf("directive: %w", err)
+		}
+		expression, err := shouldCastAsExpression(children[3])
+		if err != nil {
+			return nil, fmt.Errorf("directive: %w", err)
+		}
+
+		return directive{name: label.Text, expression: expression, position: node.Start}, nil
+	})
+
+	visitImportDirective := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 6); err != nil {
+			return nil, err
 		}
-		otherTerms, err := shouldCastAsExpressions(children[1])
+
+		file, err := shouldCastAsExpressionWithType[*types.Literal](children[2])
+		if err != nil {
+			return nil, fmt.Errorf("import_directive: %w", err)
+		}
+		namespace, err := shouldCastAsNode(children[5])
+		if err != nil {
+			return nil, fmt.Errorf("import_directive: %w", err)
+		}
+
+		return importDirective{path: file.GetLiteral(), namespace: namespace.Text, position: node.Start}, nil
+	})
+
+	visitDisplayName := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if len(children) == 0 {
+			return "", nil
+		}
+
+		literal, err := shouldCastAsExpressionWithType[*types.Literal](children[0])
+		if err != nil {
+			return nil, fmt.Errorf("display_name: %w", err)
+		}
+
+		return literal.GetLiteral(), nil
+	})
+
+	visitSequence := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
+			return nil, err
+		}
+
+		sequenceMembers, labels, err := shouldCastAsMembers(children[0], children[1])
 		if err != nil {
 			return nil, fmt.Errorf("sequence: %w", err)
 		}
 
-		sequenceMembers := append([]types.Expression{term}, otherTerms...)
 		debugf("creating sequence members with length %d\n", len(sequenceMembers))
-		return types.NewSequence("", sequenceMembers), nil
+		sequence := types.NewSequence("", sequenceMembers)
+		sequence.SetLabels(labels)
+		return sequence, nil
 	})
 
 	visitOred := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
@@ -318,18 +475,35 @@ func createRuleVisitor(
 			return nil, err
 		}
 
-		firstTerm, err := shouldCastAsExpression(children[0])
+		terms, labels, err := shouldCastAsMembers(children[0], children[1])
 		if err != nil {
 			return nil, fmt.Errorf("ored: %w", err)
 		}
-		otherTerms, err := shouldCastAsExpressions(children[1])
+
+		debugf("creating oneOf members with length %d\n", len(terms))
+		oneOf := types.NewOneOf("", terms)
+		oneOf.SetLabels(labels)
+		return oneOf, nil
+	})
+
+	visitNamedTerm := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
+			return nil, err
+		}
+
+		label, err := shouldCastAsNode(children[0])
 		if err != nil {
-			return nil, fmt.Errorf("ored: %w", err)
+			return nil, fmt.Errorf("named_term: %w", err)
+		}
+		if _, ok := children[3].(namedTerm); ok {
+			return nil, fmt.Errorf("named_term: term %q is labeled more than once", label.Text)
+		}
+		term, err := shouldCastAsExpression(children[3])
+		if err != nil {
+			return nil, fmt.Errorf("named_term: %w", err)
 		}
 
-		terms := append([]types.Expression{firstTerm}, otherTerms...)
-		debugf("creating oneOf members with length %d\n", len(terms))
-		return types.NewOneOf("", terms), nil
+		return namedTerm{name: label.Text, expression: term}, nil
 	})
 
 	visitOrTerm := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
@@ -365,43 +539,230 @@ func createRuleVisitor(
 		return types.NewLazyReference(label.Text), nil
 	})
 
-	visitRegex := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+	visitCall := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 8); err != nil {
+			return nil, err
+		}
+
+		name, err := shouldCastAsNode(children[0])
+		if err != nil {
+			return nil, fmt.Errorf("call: %w", err)
+		}
+		args := []any{children[3]}
+		if rest, ok := children[4].([]any); ok {
+			args = append(args, rest...)
+		}
+		argExpressions, err := shouldCastAsExpressions(args)
+		if err != nil {
+			return nil, fmt.Errorf("call: %w", err)
+		}
+
+		return types.NewLazyReferenceWithArgs(name.Text, argExpressions), nil
+	})
+
+	visitPredicate := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 6); err != nil {
+			return nil, err
+		}
+
+		prefix, err := shouldCastAsNode(children[0])
+		if err != nil {
+			return nil, fmt.Errorf("predicate: %w", err)
+		}
+		label, err := shouldCastAsNode(children[3])
+		if err != nil {
+			return nil, fmt.Errorf("predicate: %w", err)
+		}
+
+		return types.NewPredicate("", label.Text, prefix.Text == "!"), nil
+	})
+
+	visitMatcher := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 5); err != nil {
+			return nil, err
+		}
+
+		label, err := shouldCastAsNode(children[3])
+		if err != nil {
+			return nil, fmt.Errorf("matcher: %w", err)
+		}
+
+		return types.NewCustomMatcher("", label.Text), nil
+	})
+
+	visitPrecedence := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 12); err != nil {
+			return nil, err
+		}
+
+		operand, err := shouldCastAsExpression(children[4])
+		if err != nil {
+			return nil, fmt.Errorf("precedence (operand): %w", err)
+		}
+
+		var operators []types.Operator
+		if rows, ok := children[9].([]any); ok {
+			for idx, row := range rows {
+				rowOperators, ok := row.([]types.Operator)
+				if !ok {
+					return nil, fmt.Errorf("precedence: expected []Operator, got %#v", row)
+				}
+				for _, operator := range rowOperators {
+					operator.Precedence = idx + 1
+					operators = append(operators, operator)
+				}
+			}
+		}
+
+		return types.NewPrecedence("", operand, operators), nil
+	})
+
+	visitOperatorRow := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
 		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
 			return nil, err
 		}
 
-		literal, err := shouldCastAsExpressionWithType[*types.Literal](children[1])
+		kind, err := shouldCastAsNode(children[0])
 		if err != nil {
-			return nil, fmt.Errorf("regex (literal): %w", err)
+			return nil, fmt.Errorf("operator_row (kind): %w", err)
+		}
+		terms, err := shouldCastAsExpressions(children[3])
+		if err != nil {
+			return nil, fmt.Errorf("operator_row: %w", err)
+		}
+
+		var operators []types.Operator
+		for _, term := range terms {
+			operator := types.Operator{Expression: term}
+			switch kind.Text {
+			case "left":
+				operator.Kind = types.Infix
+				operator.Associativity = types.LeftAssociative
+			case "right":
+				operator.Kind = types.Infix
+				operator.Associativity = types.RightAssociative
+			case "prefix":
+				operator.Kind = types.Prefix
+			case "postfix":
+				operator.Kind = types.Postfix
+			default:
+				return nil, fmt.Errorf("operator_row: unknown operator kind %q", kind.Text)
+			}
+			operators = append(operators, operator)
 		}
-		pattern := "^" + literal.GetLiteral()
 
-		var reOptions regexp2.RegexOptions = regexp2.Unicode
-		flags, err := shouldCastAsNode(children[2])
+		return operators, nil
+	})
+
+	visitOperatorTerm := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
+			return nil, err
+		}
+
+		return children[1], nil
+	})
+
+	visitCut := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
+			return nil, err
+		}
+
+		return types.NewCut(), nil
+	})
+
+	visitLabeled := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
+			return nil, err
+		}
+
+		term, err := shouldCastAsExpression(children[0])
 		if err != nil {
-			return nil, fmt.Errorf("regex (flags): %w", err)
+			return nil, fmt.Errorf("labeled: %w", err)
+		}
+		label, ok := children[3].(failureLabel)
+		if !ok {
+			return nil, fmt.Errorf("labeled: expected failure label, got %#v", children[3])
+		}
+
+		return types.NewThrow("", term, label.label, label.message), nil
+	})
+
+	visitFailureLabel := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 1); err != nil {
+			return nil, err
+		}
+
+		switch v := children[0].(type) {
+		case failureLabel:
+			return v, nil
+		case *types.Node:
+			return failureLabel{label: v.Text}, nil
+		case *types.Literal:
+			return failureLabel{message: v.GetLiteral()}, nil
+		default:
+			return nil, fmt.Errorf("failure_label: unexpected %#v", v)
+		}
+	})
+
+	visitFailureLabelWithMessage := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
+			return nil, err
+		}
+
+		label, err := shouldCastAsNode(children[0])
+		if err != nil {
+			return nil, fmt.Errorf("failure_label_with_message: %w", err)
 		}
-		flagsText := strings.ToLower(flags.Text)
-		if strings.Contains(flagsText, "i") {
-			reOptions |= regexp2.IgnoreCase
+		message, err := shouldCastAsExpressionWithType[*types.Literal](children[3])
+		if err != nil {
+			return nil, fmt.Errorf("failure_label_with_message: %w", err)
 		}
-		if strings.Contains(flagsText, "l") {
-			return nil, fmt.Errorf("regex (flags): flag 'l' is not supported")
+
+		return failureLabel{label: label.Text, message: message.GetLiteral()}, nil
+	})
+
+	visitRegex := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 4); err != nil {
+			return nil, err
 		}
-		if strings.Contains(flagsText, "m") {
-			reOptions |= regexp2.Multiline
+
+		literal, err := shouldCastAsExpressionWithType[*types.Literal](children[1])
+		if err != nil {
+			return nil, fmt.Errorf("regex (literal): %w", err)
 		}
-		if strings.Contains(flagsText, "s") {
-			reOptions |= regexp2.Singleline
+		flags, err := shouldCastAsNode(children[2])
+		if err != nil {
+			return nil, fmt.Errorf("regex (flags): %w", err)
 		}
 
+		pattern, reOptions, groupNames, err := translatePythonRegex(literal.GetLiteral(), flags.Text)
+		if err != nil {
+			return nil, &types.ErrInvalidGrammar{
+				Position: node.Start,
+				Err:      fmt.Errorf("regex %q: %w", literal.GetLiteral(), err),
+			}
+		}
 		re, err := regexp2.Compile(pattern, reOptions)
 		if err != nil {
-			return nil, fmt.Errorf("regex: %q %w", pattern, err)
+			return nil, &types.ErrInvalidGrammar{
+				Position: node.Start,
+				Err:      fmt.Errorf("regex %q: %w", literal.GetLiteral(), err),
+			}
+		}
+		// the pattern is a group anchored at the start, anchoring it at the end as well
+		// matches the whole text of a token in token mode, rather than the leftmost match
+		tokenRe, err := regexp2.Compile(pattern+`\z`, reOptions)
+		if err != nil {
+			return nil, &types.ErrInvalidGrammar{
+				Position: node.Start,
+				Err:      fmt.Errorf("regex %q: %w", literal.GetLiteral(), err),
+			}
 		}
 
-		debugf("regex pattern: %q, flags: %q\n", pattern, flagsText)
-		return types.NewRegex("", re), nil
+		debugf("regex pattern: %q, flags: %q\n", pattern, flags.Text)
+		rv := types.NewRegexWithTokenRegex("", re, tokenRe)
+		rv.SetGroupNames(groupNames)
+		return rv, nil
 	})
 
 	visitSpacelessLiteral := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
@@ -409,61 +770,96 @@ func createRuleVisitor(
 		literalValue, err := evalPythonStringValue(node.Text)
 		if err != nil {
 			//debugf("spaceless literal %q eval failed %s\n", node.Text, err)
-			return nil, fmt.Errorf("spaceless literal: %q %w", node.Text, err)
+			return nil, &types.ErrInvalidGrammar{
+				Position: node.Start,
+				Err:      fmt.Errorf("spaceless literal: %q %w", node.Text, err),
+			}
 		}
 
 		//debugf("spaceless literal %q matched with literal %q\n", node.Text, literalValue)
 		return types.NewLiteral(literalValue), nil
 	})
 
-	visitLiteral := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+	visitCharClass := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
 		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
 			return nil, err
 		}
 
-		return children[0], nil
+		source, err := shouldCastAsNode(children[0])
+		if err != nil {
+			return nil, fmt.Errorf("char_class: %w", err)
+		}
+		charClass, err := types.NewCharClass("", source.Text)
+		if err != nil {
+			return nil, fmt.Errorf("char_class: %w", err)
+		}
+
+		return charClass, nil
 	})
 
-	// FIXME: this visitor is returning non expression value, which makes us to fallback to any :(
-	visitRules := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+	visitAnyChar := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
 		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
 			return nil, err
 		}
 
-		rules, err := shouldCastAsExpressions(children[1])
+		return types.NewAnyChar(""), nil
+	})
+
+	visitLiteral := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		// literals of the bootstrap grammar have no flags
+		if len(children) == 2 {
+			return children[0], nil
+		}
+		if err := assertNodeToHaveChildrenCount(node, children, 3); err != nil {
+			return nil, err
+		}
+
+		literal, err := shouldCastAsExpressionWithType[*types.Literal](children[0])
+		if err != nil {
+			return nil, fmt.Errorf("literal: %w", err)
+		}
+		flags, err := shouldCastAsNode(children[1])
 		if err != nil {
-			return nil, fmt.Errorf("rules: %w", err)
+			return nil, fmt.Errorf("literal: %w", err)
+		}
+		if flags.Text == "i" {
+			return types.NewCaseInsensitiveLiteral(literal.GetLiteral()), nil
 		}
 
-		var knownRuleNames []string
-		rulesMap := make(map[string]types.Expression)
-		for _, rule := range rules {
-			ruleName := rule.ExprName()
+		return literal, nil
+	})
 
-			rulesMap[ruleName] = rule
-			knownRuleNames = append(knownRuleNames, ruleName)
+	// FIXME: this visitor is returning non expression value, which makes us to fallback to any :(
+	visitRules := debugHandleExpr(func(node *types.Node, children []any) (any, error) {
+		if err := assertNodeToHaveChildrenCount(node, children, 2); err != nil {
+			return nil, err
 		}
-		for _, rule := range customRules {
-			ruleName := rule.ExprName()
-			if _, ok := rulesMap[ruleName]; !ok {
-				knownRuleNames = append(knownRuleNames, ruleName)
-			}
-			rulesMap[ruleName] = rule
+
+		var rv grammarDefinitions
+		if n, ok := children[1].(*types.Node); ok && len(n.Children) == 0 {
+			// the text has no definitions
+			return rv, nil
 		}
-		for _, k := range knownRuleNames {
-			v := rulesMap[k]
-			// debugf("resolving refs for %q (known rule names: %q)\n", k, knownRuleNames)
-			resolved, err := types.ResolveRefsFor(v, rulesMap)
+		definitions, ok := children[1].([]any)
+		if !ok {
+			return nil, fmt.Errorf("rules: expected definitions, got %T", children[1])
+		}
+		for _, definition := range definitions {
+			switch d := definition.(type) {
+			case directive:
+				rv.directives = append(rv.directives, d)
+				continue
+			case importDirective:
+				rv.imports = append(rv.imports, d)
+				continue
+			}
+			rule, err := shouldCastAsExpression(definition)
 			if err != nil {
-				return nil, fmt.Errorf("resolve refs for %q: %w", k, err)
+				return nil, fmt.Errorf("rules: %w", err)
 			}
-			rulesMap[k] = resolved
+			rv.rules = append(rv.rules, rule)
 		}
 
-		defaultRule := rulesMap[rules[0].ExprName()]
-		rv := types.NewGrammar(rulesMap, defaultRule)
-		debugf("loaded %d rules, default rule: %s\n", len(rulesMap), defaultRule)
-
 		return rv, nil
 	})
 
@@ -476,16 +872,130 @@ func createRuleVisitor(
 		HandleExpr("quantified", visitQuantified).
 		HandleExpr("lookahead_term", visitLookaheadTerm).
 		HandleExpr("not_term", visitNotTerm).
+		HandleExpr("definition", liftChild).
+		HandleExpr("directive", visitDirective).
+		HandleExpr("import_directive", visitImportDirective).
 		HandleExpr("rule", visitRule).
+		HandleExpr("annotations", visitAnnotations).
+		HandleExpr("annotation", visitAnnotation).
+		HandleExpr("display_name", visitDisplayName).
+		HandleExpr("display_name_text", liftChild).
+		HandleExpr("rule_params", visitRuleParams).
+		HandleExpr("rule_param_list", visitRuleParamList).
+		HandleExpr("rule_param", visitOrTerm).
 		HandleExpr("sequence", visitSequence).
 		HandleExpr("ored", visitOred).
 		HandleExpr("or_term", visitOrTerm).
+		HandleExpr("named_term", visitNamedTerm).
 		HandleExpr("label", visitLabel).
 		HandleExpr("reference", visitReference).
+		HandleExpr("reference_name", visitLabel).
+		HandleExpr("call", visitCall).
+		HandleExpr("call_argument", visitOrTerm).
+		HandleExpr("predicate", visitPredicate).
+		HandleExpr("matcher", visitMatcher).
+		HandleExpr("precedence", visitPrecedence).
+		HandleExpr("operator_row", visitOperatorRow).
+		HandleExpr("operator_kind", visitLabel).
+		HandleExpr("operator_term", visitOperatorTerm).
+		HandleExpr("cut", visitCut).
+		HandleExpr("labeled", visitLabeled).
+		HandleExpr("labeled_term", liftChild).
+		HandleExpr("failure_label", visitFailureLabel).
+		HandleExpr("failure_label_with_message", visitFailureLabelWithMessage).
 		HandleExpr("regex", visitRegex).
 		HandleExpr("spaceless_literal", visitSpacelessLiteral).
 		HandleExpr("literal", visitLiteral).
+		HandleExpr("char_class", visitCharClass).
+		HandleExpr("any_char", visitAnyChar).
 		HandleExpr("rules", visitRules)
 
 	return mux
 }
+
+// buildGrammar creates the grammar from the definitions, with references of the rules resolved.
+// The custom rules are added when the definitions don't define them, and the loader imports
+// the grammars of @import directives, nil if imports are not supported.
+func buildGrammar(
+	definitions grammarDefinitions,
+	customRules []types.Expression,
+	loader *grammarLoader,
+) (*types.Grammar, error) {
+	if len(definitions.rules) < 1 {
+		return nil, fmt.Errorf("rules: no rule is defined")
+	}
+
+	baseRules := make(map[string]types.Expression)
+	for _, rule := range createBuiltinRules() {
+		baseRules[rule.ExprName()] = rule
+	}
+	for _, rule := range customRules {
+		baseRules[rule.ExprName()] = rule
+	}
+	for _, d := range definitions.imports {
+		if loader == nil {
+			return nil, fmt.Errorf("rules: @import is not supported")
+		}
+		imported, err := loader.importGrammar(d)
+		if err != nil {
+			return nil, err
+		}
+		if err := addImportedRules(baseRules, d.namespace, imported); err != nil {
+			return nil, &types.ErrInvalidGrammar{Position: d.position, Err: err}
+		}
+	}
+
+	// the default rule and the directives are resolved with the rules,
+	// the default rule is the first rule which isn't parameterized
+	var defaultRule types.Expression
+	for _, rule := range definitions.rules {
+		if _, ok := rule.(*types.RuleTemplate); !ok {
+			defaultRule = types.NewLazyReference(rule.ExprName())
+			break
+		}
+	}
+	if defaultRule == nil {
+		return nil, fmt.Errorf("rules: no rule without parameters is defined")
+	}
+	rv := types.NewGrammar(baseRules, defaultRule)
+	var start, skip *directive
+	for idx, d := range definitions.directives {
+		switch d.name {
+		case "skip":
+			if skip != nil {
+				return nil, &types.ErrInvalidGrammar{Position: d.position, Err: fmt.Errorf("@skip is defined more than once")}
+			}
+			skip = &definitions.directives[idx]
+			rv = rv.WithSkipExpression(d.expression)
+		case "start":
+			if start != nil {
+				return nil, &types.ErrInvalidGrammar{Position: d.position, Err: fmt.Errorf("@start is defined more than once")}
+			}
+			if _, ok := d.expression.(*types.LazyReference); !ok {
+				return nil, &types.ErrInvalidGrammar{
+					Position: d.position,
+					Err:      fmt.Errorf("@start should be a rule name, got %s", d.expression),
+				}
+			}
+			start = &definitions.directives[idx]
+		default:
+			return nil, &types.ErrInvalidGrammar{Position: d.position, Err: fmt.Errorf("unknown directive @%s", d.name)}
+		}
+	}
+
+	rules := make(map[string]types.Expression, len(definitions.rules))
+	for _, rule := range definitions.rules {
+		rules[rule.ExprName()] = rule
+	}
+	rv, err := rv.WithRules(rules)
+	if err != nil {
+		return nil, err
+	}
+	if start != nil {
+		rv, err = rv.Default(start.expression.(*types.LazyReference).ReferenceName())
+		if err != nil {
+			return nil, &types.ErrInvalidGrammar{Position: start.position, Err: err}
+		}
+	}
+	return rv, nil
+}
@@ -0,0 +1,56 @@
+package bootstrap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/b4fun/parsimonious-go/nodes"
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewGrammar_InlinePrecedence(t *testing.T) {
+	grammar, err := NewGrammar(`
+statements = sum ";" product
+sum = "sum " %precedence(number) { left: "+" }
+product = "product " %precedence(number) { left: "*" }
+number = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("sum 1+2;product 3*4")
+	assert.NoError(t, err)
+
+	visit := func(op string) nodes.PrecedenceVisitFunc {
+		return func(node *types.Node, operator *types.Node, operands []any) (any, error) {
+			return fmt.Sprintf("%s(%v, %v)", op, operands[0], operands[1]), nil
+		}
+	}
+	mux := nodes.NewNodeVisitorMux().
+		HandleOperations("sum", visit("add")).
+		HandleOperations("product", visit("mul")).
+		HandleExpr("statements", func(node *types.Node, children []any) (any, error) {
+			return fmt.Sprintf("%v %v", children[0], children[2]), nil
+		}).
+		HandleExpr("sum", func(node *types.Node, children []any) (any, error) {
+			return children[1].([]any)[0], nil
+		}).
+		HandleExpr("product", func(node *types.Node, children []any) (any, error) {
+			return children[1].([]any)[0], nil
+		}).
+		HandleExpr("number", func(node *types.Node, children []any) (any, error) {
+			return node.Text, nil
+		})
+	result, err := mux.Visit(tree)
+	assert.NoError(t, err)
+	assert.Equal(t, "add(1, 2) mul(3, 4)", result)
+
+	_, err = NewGrammar(`
+both = %precedence(number) { left: "+" } ";" %precedence(number) { left: "*" }
+number = ~"[0-9]+"
+`)
+	var invalidGrammar *types.ErrInvalidGrammar
+	if assert.ErrorAs(t, err, &invalidGrammar) {
+		assert.ErrorContains(t, err, `rule "both" has 2 inline precedence expressions`)
+	}
+}
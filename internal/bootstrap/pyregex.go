@@ -0,0 +1,317 @@
+package bootstrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dlclark/regexp2"
+)
+
+// asciiClasses are the ASCII only sets of the Perl classes, used in Python's ASCII mode.
+var asciiClasses = map[rune]string{
+	'd': `0-9`,
+	'w': `a-zA-Z0-9_`,
+	's': ` \t\n\r\f\v`,
+}
+
+// translatePythonRegex translates a Python regex pattern and flags to the pattern and options of regexp2.
+// The translated pattern is anchored at the start, as the pattern is matched at the parsing position.
+//
+// The translation covers (?P<name>...), (?P=name), \Z, \UXXXXXXXX, {,n}, the inline flags
+// and the a (ASCII), u (Unicode), x (verbose) flags. Unsupported constructs are reported as errors.
+//
+// regexp2 numbers the named groups after the unnamed ones, while Python numbers all the groups
+// from left to right. The named groups are translated into unnamed groups, so they're numbered
+// as in Python, and the names of the groups are returned by their numbers from 1, empty for unnamed groups.
+func translatePythonRegex(pattern string, flags string) (string, regexp2.RegexOptions, []string, error) {
+	var options regexp2.RegexOptions = regexp2.Unicode
+	ascii := false
+	verbose := false
+	for _, flag := range strings.ToLower(flags) {
+		switch flag {
+		case 'i':
+			options |= regexp2.IgnoreCase
+		case 'm':
+			options |= regexp2.Multiline
+		case 's':
+			options |= regexp2.Singleline
+		case 'x':
+			options |= regexp2.IgnorePatternWhitespace
+			verbose = true
+		case 'a':
+			ascii = true
+		case 'u':
+			// patterns are Unicode by default
+		case 'l':
+			return "", 0, nil, fmt.Errorf("flag 'l' is not supported, it only applies to bytes patterns in Python")
+		}
+	}
+
+	t := &pythonRegexTranslator{pattern: []rune(pattern), ascii: ascii, verbose: verbose}
+	translated, err := t.translate()
+	if err != nil {
+		return "", 0, nil, err
+	}
+	if t.verbose {
+		// end the trailing comment before closing the group
+		translated += "\n"
+	}
+	return "^(?:" + translated + ")", options, t.groups, nil
+}
+
+type pythonRegexTranslator struct {
+	pattern []rune
+	ascii   bool
+	verbose bool
+	sb      strings.Builder
+	// groups are the names of the capture groups translated so far, by their numbers from 1.
+	groups []string
+}
+
+// groupNumber returns the number of the named group, 0 if there's no such group.
+func (t *pythonRegexTranslator) groupNumber(name string) int {
+	for idx, group := range t.groups {
+		if group != "" && group == name {
+			return idx + 1
+		}
+	}
+	return 0
+}
+
+func (t *pythonRegexTranslator) translate() (string, error) {
+	inClass := false
+	for idx := 0; idx < len(t.pattern); {
+		ch := t.pattern[idx]
+		switch {
+		case ch == '\\':
+			next, err := t.translateEscape(idx, inClass)
+			if err != nil {
+				return "", err
+			}
+			idx = next
+			continue
+		case inClass:
+			if ch == ']' {
+				inClass = false
+			}
+		case ch == '#' && t.verbose:
+			// comments are copied as they are
+			end := t.indexOf(idx, '\n')
+			if end < 0 {
+				end = len(t.pattern)
+			}
+			t.sb.WriteString(string(t.pattern[idx:end]))
+			idx = end
+			continue
+		case ch == '[':
+			inClass = true
+			t.sb.WriteRune(ch)
+			idx++
+			// a leading ] is a literal in the class
+			if idx < len(t.pattern) && t.pattern[idx] == '^' {
+				t.sb.WriteRune('^')
+				idx++
+			}
+			if idx < len(t.pattern) && t.pattern[idx] == ']' {
+				t.sb.WriteString(`\]`)
+				idx++
+			}
+			continue
+		case ch == '(' && t.hasPrefix(idx, "(?"):
+			next, err := t.translateGroup(idx)
+			if err != nil {
+				return "", err
+			}
+			idx = next
+			continue
+		case ch == '(':
+			t.groups = append(t.groups, "")
+		case ch == '{' && t.hasPrefix(idx, "{,"):
+			// {,n} is {0,n} in Python
+			if end := t.indexOf(idx, '}'); end > 0 && isDigits(t.pattern[idx+2:end]) && end > idx+2 {
+				t.sb.WriteString("{0," + string(t.pattern[idx+2:end]) + "}")
+				idx = end + 1
+				continue
+			}
+		}
+		t.sb.WriteRune(ch)
+		idx++
+	}
+	if inClass {
+		return "", fmt.Errorf("unterminated character set")
+	}
+
+	return t.sb.String(), nil
+}
+
+func (t *pythonRegexTranslator) hasPrefix(idx int, prefix string) bool {
+	for _, r := range prefix {
+		if idx >= len(t.pattern) || t.pattern[idx] != r {
+			return false
+		}
+		idx++
+	}
+	return true
+}
+
+func (t *pythonRegexTranslator) indexOf(idx int, ch rune) int {
+	for end := idx; end < len(t.pattern); end++ {
+		if t.pattern[end] == ch {
+			return end
+		}
+	}
+	return -1
+}
+
+func isDigits(rs []rune) bool {
+	for _, r := range rs {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// translateEscape translates the escape sequence at idx, and returns the index after it.
+func (t *pythonRegexTranslator) translateEscape(idx int, inClass bool) (int, error) {
+	if idx+1 >= len(t.pattern) {
+		return 0, fmt.Errorf("bad escape (end of pattern)")
+	}
+
+	escaped := t.pattern[idx+1]
+	next := idx + 2
+	switch escaped {
+	case 'Z':
+		// \Z matches only at the end of string in Python
+		t.sb.WriteString(`\z`)
+	case 'U':
+		if next+8 > len(t.pattern) {
+			return 0, fmt.Errorf(`incomplete escape \U`)
+		}
+		v, err := strconv.ParseUint(string(t.pattern[next:next+8]), 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf(`bad escape \U%s`, string(t.pattern[next:next+8]))
+		}
+		t.sb.WriteString(regexp2.Escape(string(rune(v))))
+		next += 8
+	case 'N':
+		return 0, fmt.Errorf(`named character escapes \N{...} are not supported`)
+	case 'd', 'w', 's':
+		if !t.ascii {
+			t.sb.WriteString(`\` + string(escaped))
+		} else if inClass {
+			t.sb.WriteString(asciiClasses[escaped])
+		} else {
+			t.sb.WriteString("[" + asciiClasses[escaped] + "]")
+		}
+	case 'D', 'W', 'S':
+		lower := escaped - 'A' + 'a'
+		if !t.ascii {
+			t.sb.WriteString(`\` + string(escaped))
+		} else if inClass {
+			return 0, fmt.Errorf(`\%c in a character set is not supported in ASCII mode`, escaped)
+		} else {
+			t.sb.WriteString("[^" + asciiClasses[lower] + "]")
+		}
+	default:
+		t.sb.WriteString(`\` + string(escaped))
+	}
+
+	return next, nil
+}
+
+// translateGroup translates the extension group starting at idx, and returns the index after the translated part.
+func (t *pythonRegexTranslator) translateGroup(idx int) (int, error) {
+	switch {
+	case t.hasPrefix(idx, "(?P<"):
+		end := t.indexOf(idx, '>')
+		if end < 0 {
+			return 0, fmt.Errorf("missing >, unterminated name")
+		}
+		name := string(t.pattern[idx+4 : end])
+		if name == "" {
+			return 0, fmt.Errorf("missing group name")
+		}
+		if t.groupNumber(name) > 0 {
+			return 0, fmt.Errorf("redefinition of group name %q", name)
+		}
+		t.groups = append(t.groups, name)
+		t.sb.WriteString("(")
+		return end + 1, nil
+	case t.hasPrefix(idx, "(?P="):
+		end := t.indexOf(idx, ')')
+		if end < 0 {
+			return 0, fmt.Errorf("missing ), unterminated name")
+		}
+		name := string(t.pattern[idx+4 : end])
+		number := t.groupNumber(name)
+		if number == 0 {
+			return 0, fmt.Errorf("unknown group name %q", name)
+		}
+		// grouped, so the digits following the backreference aren't taken as a part of the number
+		t.sb.WriteString(fmt.Sprintf(`(?:\%d)`, number))
+		return end + 1, nil
+	case t.hasPrefix(idx, "(?("):
+		// conditional on a group: (?(name)yes|no) or (?(number)yes|no)
+		end := t.indexOf(idx, ')')
+		if end < 0 {
+			return 0, fmt.Errorf("missing ), unterminated name")
+		}
+		name := string(t.pattern[idx+3 : end])
+		if !isDigits(t.pattern[idx+3 : end]) {
+			number := t.groupNumber(name)
+			if number == 0 {
+				return 0, fmt.Errorf("unknown group name %q", name)
+			}
+			name = fmt.Sprint(number)
+		}
+		t.sb.WriteString("(?(" + name + ")")
+		return end + 1, nil
+	case t.hasPrefix(idx, "(?P"):
+		if idx+3 >= len(t.pattern) {
+			return 0, fmt.Errorf("unexpected end of pattern")
+		}
+		return 0, fmt.Errorf("unknown extension ?P%c", t.pattern[idx+3])
+	}
+
+	// inline flags: (?aiLmsux) or (?aiLmsux-imsx:...)
+	end := idx + 2
+	for end < len(t.pattern) && strings.ContainsRune("aiLmsux-", t.pattern[end]) {
+		end++
+	}
+	if end == idx+2 || end >= len(t.pattern) || (t.pattern[end] != ')' && t.pattern[end] != ':') {
+		// not inline flags, e.g. (?:...), (?=...) or (?<name>...)
+		t.sb.WriteString("(?")
+		return idx + 2, nil
+	}
+
+	scoped := t.pattern[end] == ':'
+	var flags strings.Builder
+	for _, flag := range t.pattern[idx+2 : end] {
+		switch flag {
+		case 'L':
+			return 0, fmt.Errorf("flag 'L' is not supported, it only applies to bytes patterns in Python")
+		case 'a':
+			if scoped {
+				return 0, fmt.Errorf("scoped flag 'a' is not supported")
+			}
+			t.ascii = true
+		case 'u':
+			// patterns are Unicode by default
+		case 'x':
+			t.verbose = t.verbose || !scoped
+			flags.WriteRune(flag)
+		default:
+			flags.WriteRune(flag)
+		}
+	}
+	switch {
+	case flags.Len() > 0 && flags.String() != "-":
+		t.sb.WriteString("(?" + flags.String() + string(t.pattern[end]))
+	case scoped:
+		t.sb.WriteString("(?:")
+	}
+
+	return end + 1, nil
+}
@@ -0,0 +1,164 @@
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/dlclark/regexp2"
+)
+
+func Test_translatePythonRegex(t *testing.T) {
+	cases := []struct {
+		name      string
+		pattern   string
+		flags     string
+		matches   []string
+		rejects   []string
+		expectErr bool
+	}{
+		{
+			name:    "named group and backreference",
+			pattern: `(?P<quote>['"]).*?(?P=quote)`,
+			matches: []string{`'a'`, `"a'b"`},
+			rejects: []string{`'a"`},
+		},
+		{
+			name:    "end of string",
+			pattern: `a\Z`,
+			matches: []string{"a"},
+			rejects: []string{"a\n"},
+		},
+		{
+			name:    "omitted lower bound",
+			pattern: `a{,2}b`,
+			matches: []string{"b", "aab"},
+			rejects: []string{"aaab"},
+		},
+		{
+			name:    "long unicode escape",
+			pattern: `\U0001F600`,
+			matches: []string{"😀"},
+		},
+		{
+			name:    "alternation is anchored",
+			pattern: `a|b`,
+			matches: []string{"a", "b"},
+			rejects: []string{"cb"},
+		},
+		{
+			name:    "ascii flag",
+			pattern: `\w+\Z`,
+			flags:   "a",
+			matches: []string{"abc_1"},
+			rejects: []string{"größe"},
+		},
+		{
+			name:    "inline ascii flag",
+			pattern: `(?a)[\d]+\Z`,
+			matches: []string{"123"},
+			rejects: []string{"١٢٣"},
+		},
+		{
+			name:    "unicode flag",
+			pattern: `\w+\Z`,
+			flags:   "u",
+			matches: []string{"größe"},
+		},
+		{
+			name:    "verbose flag",
+			pattern: "[a-z]+  # name\n  [#]  # hash",
+			flags:   "x",
+			matches: []string{"abc#"},
+			rejects: []string{"abc #"},
+		},
+		{
+			name:    "scoped inline flag",
+			pattern: `(?i:a)b`,
+			matches: []string{"Ab"},
+			rejects: []string{"AB"},
+		},
+		{
+			name:      "locale flag",
+			pattern:   `a`,
+			flags:     "l",
+			expectErr: true,
+		},
+		{
+			name:      "named character escape",
+			pattern:   `\N{DIGIT ONE}`,
+			expectErr: true,
+		},
+		{
+			name:      "unknown extension",
+			pattern:   `(?Px)`,
+			expectErr: true,
+		},
+		{
+			name:    "groups numbered from left to right",
+			pattern: `(?P<a>x)(y)\2`,
+			matches: []string{"xyy"},
+			rejects: []string{"xyx"},
+		},
+		{
+			name:    "named backreference followed by digits",
+			pattern: `(a)(b)(c)(d)(e)(f)(g)(h)(i)(?P<j>j)(?P=j)0`,
+			matches: []string{"abcdefghijj0"},
+		},
+		{
+			name:    "conditional on a group number",
+			pattern: `(<)?(?P<a>x)(?(1)>)`,
+			matches: []string{"<x>", "x"},
+			rejects: []string{"<x"},
+		},
+		{
+			name:    "conditional on a group name",
+			pattern: `(?P<a><)?x(?(a)>)`,
+			matches: []string{"<x>", "x"},
+			rejects: []string{"<x"},
+		},
+		{
+			name:      "backreference to an unknown group",
+			pattern:   `(?P<a>x)(?P=b)`,
+			expectErr: true,
+		},
+		{
+			name:      "redefined group name",
+			pattern:   `(?P<a>x)(?P<a>y)`,
+			expectErr: true,
+		},
+		{
+			name:      "unterminated character set",
+			pattern:   `[a-z`,
+			expectErr: true,
+		},
+	}
+
+	for idx := range cases {
+		c := cases[idx]
+		t.Run(c.name, func(t *testing.T) {
+			pattern, options, _, err := translatePythonRegex(c.pattern, c.flags)
+			if c.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			re, err := regexp2.Compile(pattern, options)
+			if err != nil {
+				t.Fatalf("compile %q: %v", pattern, err)
+			}
+			for _, s := range c.matches {
+				if m, _ := re.FindStringMatch(s); m == nil || m.String() != s {
+					t.Errorf("%q should match %q fully", pattern, s)
+				}
+			}
+			for _, s := range c.rejects {
+				if m, _ := re.FindStringMatch(s); m != nil && m.String() == s {
+					t.Errorf("%q should not match %q fully", pattern, s)
+				}
+			}
+		})
+	}
+}
@@ -2,6 +2,9 @@ package bootstrap
 
 import (
 	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/b4fun/parsimonious-go/nodes"
 	"github.com/b4fun/parsimonious-go/types"
@@ -89,9 +92,8 @@ var pythonStringExpr, pythonStringVisitor = func() (types.Expression, *nodes.Nod
 		if err != nil {
 			return nil, err
 		}
-		rv := literalNode.Text
 
-		return rv, nil
+		return decodePythonEscapes(literalNode.Text)
 	}
 
 	visitRawString := func(node *types.Node, children []any) (any, error) {
@@ -144,3 +146,87 @@ func evalPythonStringValue(input string) (string, error) {
 
 	return s, nil
 }
+
+// decodePythonEscapes decodes the escape sequences of a non-raw Python string literal.
+// As in Python, unrecognized escape sequences are left in the string unchanged.
+// The \N{name} escapes are rejected, as there's no Unicode name table to look the names up,
+// and so are the lone surrogates, which Go strings can't hold.
+func decodePythonEscapes(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+
+	var sb strings.Builder
+	for idx := 0; idx < len(s); {
+		if s[idx] != '\\' || idx+1 >= len(s) {
+			sb.WriteByte(s[idx])
+			idx++
+			continue
+		}
+
+		escaped := s[idx+1]
+		idx += 2
+		switch escaped {
+		case '\n':
+			// line continuation
+		case '\\', '\'', '"':
+			sb.WriteByte(escaped)
+		case 'a':
+			sb.WriteByte('\a')
+		case 'b':
+			sb.WriteByte('\b')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case 'v':
+			sb.WriteByte('\v')
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := idx - 1
+			for end < len(s) && end < idx+2 && s[end] >= '0' && s[end] <= '7' {
+				end++
+			}
+			v, err := strconv.ParseUint(s[idx-1:end], 8, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid octal escape \\%s: %w", s[idx-1:end], err)
+			}
+			sb.WriteRune(rune(v))
+			idx = end
+		case 'x', 'u', 'U':
+			digits := 2
+			switch escaped {
+			case 'u':
+				digits = 4
+			case 'U':
+				digits = 8
+			}
+			if idx+digits > len(s) {
+				return "", fmt.Errorf("truncated \\%c escape", escaped)
+			}
+			v, err := strconv.ParseUint(s[idx:idx+digits], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid \\%c escape %q: %w", escaped, s[idx:idx+digits], err)
+			}
+			if v > utf8.MaxRune {
+				return "", fmt.Errorf("invalid \\%c escape %q: out of range", escaped, s[idx:idx+digits])
+			}
+			if v >= 0xd800 && v <= 0xdfff {
+				// Go strings can't hold surrogates, which would be replaced with U+FFFD
+				return "", fmt.Errorf("invalid \\%c escape %q: lone surrogate", escaped, s[idx:idx+digits])
+			}
+			sb.WriteRune(rune(v))
+			idx += digits
+		case 'N':
+			return "", fmt.Errorf("\\N{...} escapes are not supported, use \\u or \\U escapes instead")
+		default:
+			sb.WriteByte('\\')
+			sb.WriteByte(escaped)
+		}
+	}
+
+	return sb.String(), nil
+}
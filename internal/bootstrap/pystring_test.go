@@ -2,6 +2,9 @@ package bootstrap
 
 import (
 	"testing"
+
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_evalPythonStringValue(t *testing.T) {
@@ -47,6 +50,41 @@ func Test_evalPythonStringValue(t *testing.T) {
 			out:       "or[@a-z][a-z_0-9\\.\\[\\]\\\"'-]",
 			expectErr: false,
 		},
+		{
+			name: "escapes",
+			in:   `"\n\t\\\"\x41\u00e9\U0001F600\101\0"`,
+			out:  "\n\t\\\"A\u00e9\U0001F600A\x00",
+		},
+		{
+			name: "unrecognized escapes are kept",
+			in:   `"\d+\s\["`,
+			out:  "\\d+\\s\\[",
+		},
+		{
+			name: "line continuation",
+			in:   "'a\\\nb'",
+			out:  "ab",
+		},
+		{
+			name: "raw string keeps escapes",
+			in:   `r"\n\x41"`,
+			out:  "\\n\\x41",
+		},
+		{
+			name:      "truncated escape",
+			in:        `"\x4"`,
+			expectErr: true,
+		},
+		{
+			name:      "invalid escape",
+			in:        `"\uzzzz"`,
+			expectErr: true,
+		},
+		{
+			name:      "lone surrogate escape",
+			in:        `"\ud800"`,
+			expectErr: true,
+		},
 	}
 
 	for idx := range cases {
@@ -65,3 +103,24 @@ func Test_evalPythonStringValue(t *testing.T) {
 		})
 	}
 }
+
+func Test_NewGrammar_InvalidStringEscape(t *testing.T) {
+	cases := map[string]string{
+		`"\ud800"`:     "lone surrogate",
+		`"\N{BULLET}"`: `\N{...} escapes are not supported`,
+		`'a\N{DASH}b'`: `\N{...} escapes are not supported`,
+	}
+	for literal, message := range cases {
+		_, err := NewGrammar(`
+name = ~"[a-z]+"
+high = ` + literal + `
+`)
+		var invalidGrammar *types.ErrInvalidGrammar
+		if assert.ErrorAs(t, err, &invalidGrammar, literal) {
+			line, column := invalidGrammar.LineAndColumn()
+			assert.Equal(t, 3, line, literal)
+			assert.Equal(t, 8, column, literal)
+			assert.Contains(t, err.Error(), message, literal)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package bootstrap
+
+import (
+	"testing"
+
+	"github.com/b4fun/parsimonious-go/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_NewGrammar_AliasRules(t *testing.T) {
+	grammar, err := NewGrammar(`
+assignment = name "=" num
+num "a number" = digits
+@token name = word
+word = letter+
+letter = ~"[a-z]"
+digits = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+
+	// the display name of the alias is kept
+	_, err = grammar.Parse("x=y")
+	assert.EqualError(t, err, `expected a number at "y" (line 1, column 3)`)
+
+	// the annotations of the alias are kept
+	tree, err := grammar.Parse("xy=1")
+	assert.NoError(t, err)
+	name := tree.Children[0]
+	assert.Equal(t, "name", name.Expression.ExprName())
+	assert.Equal(t, "xy", name.Text)
+	assert.Empty(t, name.Children)
+
+	num, ok := grammar.GetRule("num")
+	assert.True(t, ok)
+	assert.Equal(t, "a number", num.DisplayName())
+	nameRule, _ := grammar.GetRule("name")
+	assert.True(t, nameRule.Annotations().Has(types.AnnotationToken))
+
+	noMemo, err := NewGrammar(`
+values = value+
+@nomemo value = digits
+digits = ~"[0-9]"
+`)
+	assert.NoError(t, err)
+	value, _ := noMemo.GetRule("value")
+	assert.True(t, value.Annotations().Has(types.AnnotationNoMemo))
+
+	// the aliases without display names or annotations are the referenced rules
+	grammar, err = NewGrammar(`
+assignment = name "=" digits
+name = word
+word = ~"[a-z]+"
+digits = ~"[0-9]+"
+`)
+	assert.NoError(t, err)
+	name2, _ := grammar.GetRule("name")
+	_, isRegex := name2.(*types.Regex)
+	assert.True(t, isRegex)
+}
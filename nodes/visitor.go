@@ -60,6 +60,101 @@ func (mux *NodeVisitorMux) HandleExpr(
 	return mux
 }
 
+// Child returns the visited child of the node labeled with the label.
+func Child(node *types.Node, children []any, label string) (any, bool) {
+	for idx := range node.Children {
+		if idx < len(children) && node.ChildLabel(idx) == label {
+			return children[idx], true
+		}
+	}
+	return nil, false
+}
+
+// ChildrenNamed returns the visited children of the node labeled with the labels, in the order of the labels.
+// The missing children are nil.
+func ChildrenNamed(node *types.Node, children []any, labels ...string) []any {
+	rv := make([]any, len(labels))
+	for idx, label := range labels {
+		rv[idx], _ = Child(node, children, label)
+	}
+	return rv
+}
+
+// LabeledVisitFunc is a function that visits a node with its parsed children by labels.
+// The unlabeled children are not included.
+type LabeledVisitFunc func(node *types.Node, children map[string]any) (any, error)
+
+// HandleLabeled registers a visitor for the expression, which visits the labeled children by labels.
+func (mux *NodeVisitorMux) HandleLabeled(
+	exprName string,
+	f LabeledVisitFunc,
+) *NodeVisitorMux {
+	return mux.HandleExpr(exprName, func(node *types.Node, children []any) (any, error) {
+		labeled := make(map[string]any)
+		for idx := range node.Children {
+			if label := node.ChildLabel(idx); label != "" && idx < len(children) {
+				labeled[label] = children[idx]
+			}
+		}
+		return f(node, labeled)
+	})
+}
+
+// PrecedenceVisitFunc is a function that visits an operation node of a precedence rule,
+// with the operator node and the parsed operands.
+type PrecedenceVisitFunc func(node *types.Node, operator *types.Node, operands []any) (any, error)
+
+// HandlePrecedence registers visitors for the tree of the precedence rule.
+// The rule node is visited as its only child, and the operation nodes are visited by f.
+func (mux *NodeVisitorMux) HandlePrecedence(
+	ruleName string,
+	f PrecedenceVisitFunc,
+) *NodeVisitorMux {
+	mux.HandleExpr(ruleName, func(node *types.Node, children []any) (any, error) {
+		if len(children) != 1 {
+			return nil, fmt.Errorf("%s should have 1 child, got %d", node, len(children))
+		}
+		return children[0], nil
+	})
+
+	return mux.HandleOperations(ruleName, f)
+}
+
+// HandleOperations registers visitors for the operation nodes of the precedence expression
+// inside the rule, rather than being the rule. The operation nodes are visited by f.
+func (mux *NodeVisitorMux) HandleOperations(
+	ruleName string,
+	f PrecedenceVisitFunc,
+) *NodeVisitorMux {
+	if ruleName == "" {
+		panic("operations of an unnamed precedence expression can't be told apart")
+	}
+
+	operatorIndexes := map[types.OperatorKind]int{
+		types.Infix:   1,
+		types.Prefix:  0,
+		types.Postfix: 1,
+	}
+	for kind, operatorIndex := range operatorIndexes {
+		operatorIndex := operatorIndex
+		mux.HandleExpr(
+			types.OperationExprName(ruleName, kind),
+			func(node *types.Node, children []any) (any, error) {
+				if len(node.Children) <= operatorIndex {
+					return nil, fmt.Errorf("%s should have an operator child", node)
+				}
+
+				operands := make([]any, 0, len(children)-1)
+				operands = append(operands, children[:operatorIndex]...)
+				operands = append(operands, children[operatorIndex+1:]...)
+				return f(node, node.Children[operatorIndex], operands)
+			},
+		)
+	}
+
+	return mux
+}
+
 func (mux *NodeVisitorMux) Visit(node *types.Node) (any, error) {
 	visitor, ok := mux.visitors[node.Expression.ExprName()]
 	if !ok {
@@ -0,0 +1,104 @@
+package indentation
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/b4fun/parsimonious-go"
+)
+
+// grammarText describes a tiny indentation based language:
+// a block statement ends with a colon, and its body is indented deeper.
+const grammarText = `
+program = blank_line* statement+
+statement = SAMEDENT (block_statement / simple_statement)
+block_statement = name arguments ":" eol block
+block = INDENT statement+ DEDENT
+simple_statement = name arguments eol
+
+name = ~"[a-z_]+"
+arguments = (" " ~"[a-z0-9_]+")*
+
+eol = ~r"[ \t]*(#[^\n]*)?(\n|$)" blank_line*
+blank_line = ~r"[ \t]*(#[^\n]*)?\n"
+`
+
+func Test_IndentationGrammar(t *testing.T) {
+	grammar, err := parsimonious.NewGrammar(grammarText)
+	if err != nil {
+		t.Errorf("parse grammar failed: %v", err)
+		return
+	}
+
+	program := `
+# a comment
+def greet name:
+    print hello
+    if name:
+        print name
+
+    print bye
+greet world
+`
+	t.Logf("%q\n", program)
+
+	tree, err := grammar.Parse(program)
+	if err != nil {
+		t.Errorf("parse sample failed: %v", err)
+		return
+	}
+	t.Log("\n" + parsimonious.DumpNodeExprTree(tree))
+
+	countBlocks := 0
+	countStatements := 0
+	mux := parsimonious.NewNodeVisitorMux().
+		HandleExpr("block", func(node *parsimonious.Node, children []any) (any, error) {
+			countBlocks++
+
+			return children, nil
+		}).
+		HandleExpr("statement", func(node *parsimonious.Node, children []any) (any, error) {
+			countStatements++
+
+			return children, nil
+		})
+	_, err = mux.Visit(tree)
+	if err != nil {
+		t.Errorf("mux visit error: %v", err)
+		return
+	}
+
+	if countBlocks != 2 {
+		t.Errorf("expect 2 blocks, got %d", countBlocks)
+		return
+	}
+	if countStatements != 6 {
+		t.Errorf("expect 6 statements, got %d", countStatements)
+		return
+	}
+}
+
+func Test_IndentationGrammar_Errors(t *testing.T) {
+	grammar, err := parsimonious.NewGrammar(grammarText)
+	if err != nil {
+		t.Errorf("parse grammar failed: %v", err)
+		return
+	}
+
+	cases := map[string]string{
+		"mixed tabs and spaces": "if x:\n    print x\n\tprint y\n",
+		"unmatched unindent":    "if x:\n    print x\n  print y\n",
+	}
+	for name, program := range cases {
+		program := program
+		t.Run(name, func(t *testing.T) {
+			_, err := grammar.Parse(program)
+			t.Log(err)
+
+			var indentationErr *parsimonious.ErrIndentation
+			if !errors.As(err, &indentationErr) {
+				t.Errorf("expect indentation error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package types
+
+import "strings"
+
+// Annotations are the rule level annotations changing how a rule is matched and built.
+type Annotations int
+
+const (
+	// AnnotationToken makes the rule a single leaf node without children.
+	// Token rules are lexical rules, which match the text exactly in skip mode.
+	AnnotationToken Annotations = 1 << iota
+	// AnnotationInline splices the children of the rule node into its parent node.
+	AnnotationInline
+	// AnnotationNoMemo matches the rule without the packrat memo table.
+	// It's meant for cheap terminals, the left recursions in such rules are still detected.
+	AnnotationNoMemo
+)
+
+var annotationNames = []struct {
+	annotation Annotations
+	name       string
+}{
+	{AnnotationToken, "token"},
+	{AnnotationInline, "inline"},
+	{AnnotationNoMemo, "nomemo"},
+}
+
+// ParseAnnotation returns the annotation of the name, as written in grammars without the leading "@".
+func ParseAnnotation(name string) (Annotations, bool) {
+	for _, a := range annotationNames {
+		if a.name == name {
+			return a.annotation, true
+		}
+	}
+	return 0, false
+}
+
+// Has reports if all of the annotations are set.
+func (a Annotations) Has(annotations Annotations) bool {
+	return a&annotations == annotations
+}
+
+func (a Annotations) String() string {
+	var names []string
+	for _, n := range annotationNames {
+		if a.Has(n.annotation) {
+			names = append(names, "@"+n.name)
+		}
+	}
+	return strings.Join(names, " ")
+}
+
+// spliceInlineChildren replaces the children nodes of inline rules with their children.
+// The labels of the spliced children are kept aligned, and their trivia are returned.
+func spliceInlineChildren(children []*Node, labels []string) ([]*Node, []string, []*Node) {
+	hasInlineChild := false
+	for _, child := range children {
+		if child.Expression.Annotations().Has(AnnotationInline) {
+			hasInlineChild = true
+			break
+		}
+	}
+	if !hasInlineChild {
+		return children, labels, nil
+	}
+
+	var trivia []*Node
+	var splicedLabels []string
+	spliced := make([]*Node, 0, len(children))
+	for idx, child := range children {
+		childLabels := []string{""}
+		if labels != nil {
+			childLabels[0] = labels[idx]
+		}
+		if child.Expression.Annotations().Has(AnnotationInline) {
+			// the label of the inline node is dropped with the node
+			childLabels = child.labels
+			if childLabels == nil {
+				childLabels = make([]string, len(child.Children))
+			}
+			spliced = append(spliced, child.Children...)
+			trivia = append(trivia, child.Trivia...)
+		} else {
+			spliced = append(spliced, child)
+		}
+		splicedLabels = append(splicedLabels, childLabels...)
+	}
+	for _, label := range splicedLabels {
+		if label != "" {
+			return spliced, splicedLabels, trivia
+		}
+	}
+
+	return spliced, nil, trivia
+}
@@ -0,0 +1,26 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Annotations_TokenDropsLabels(t *testing.T) {
+	// @token pair = k:~"[a-z]+" "=" v:~"[0-9]+"
+	pair := NewSequence("pair", []Expression{
+		NewRegex("", regexp2.MustCompile(`^[a-z]+`, regexp2.RE2)),
+		NewLiteral("="),
+		NewRegex("", regexp2.MustCompile(`^[0-9]+`, regexp2.RE2)),
+	})
+	pair.SetLabels([]string{"k", "", "v"})
+	pair.SetAnnotations(AnnotationToken)
+
+	tree, err := ParseWithExpression(pair, "a=1")
+	assert.NoError(t, err)
+	assert.Empty(t, tree.Children)
+	assert.Nil(t, tree.Child("v"))
+	assert.Equal(t, "", tree.ChildLabel(0))
+	assert.Equal(t, "a=1", tree.Text)
+}
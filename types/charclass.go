@@ -0,0 +1,333 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// runeRange is an inclusive range of runes.
+type runeRange struct {
+	lo, hi rune
+}
+
+// unicodeClass is a Unicode category or script in a character class.
+type unicodeClass struct {
+	table   *unicode.RangeTable
+	negated bool
+}
+
+// CharClass matches a single character in a set, like [a-zA-Z_] or [^"\\].
+//
+// The set can contain characters, ranges, escapes (\n, \t, \]), the Perl classes \d, \w, \s,
+// and Unicode categories or scripts like \p{L} and \P{Greek}.
+// ASCII characters are looked up from a bitmap, other characters from sorted ranges and Unicode tables.
+type CharClass struct {
+	expression
+
+	name    string
+	source  string
+	negated bool
+	ascii   [2]uint64
+	ranges  []runeRange
+	classes []unicodeClass
+}
+
+var _ Expression = (*CharClass)(nil)
+var _ exprImpl = (*CharClass)(nil)
+
+// NewCharClass creates a character class from its source in the form of [...] or [^...].
+func NewCharClass(name string, source string) (*CharClass, error) {
+	rv := &CharClass{
+		name:   name,
+		source: source,
+	}
+	rv.expression = expression{impl: rv}
+
+	if err := rv.parse(source); err != nil {
+		return nil, fmt.Errorf("invalid character class %s: %w", source, err)
+	}
+
+	return rv, nil
+}
+
+func (c *CharClass) exprName() string {
+	return c.name
+}
+
+func (c *CharClass) setExprName(n string) {
+	c.name = n
+}
+
+func (c *CharClass) parse(source string) error {
+	if !strings.HasPrefix(source, "[") || !strings.HasSuffix(source, "]") || len(source) < 3 {
+		return fmt.Errorf("should be in the form of [...]")
+	}
+	body := []rune(source[1 : len(source)-1])
+	if len(body) > 0 && body[0] == '^' {
+		c.negated = true
+		body = body[1:]
+	}
+	if len(body) == 0 {
+		return fmt.Errorf("empty set")
+	}
+
+	var ranges []runeRange
+	for idx := 0; idx < len(body); {
+		lo, class, next, err := c.parseItem(body, idx)
+		if err != nil {
+			return err
+		}
+		idx = next
+		if class != nil {
+			ranges = append(ranges, class...)
+			continue
+		}
+
+		hi := lo
+		if idx+1 < len(body) && body[idx] == '-' {
+			var hiClass []runeRange
+			hi, hiClass, next, err = c.parseItem(body, idx+1)
+			if err != nil {
+				return err
+			}
+			if hiClass != nil {
+				return fmt.Errorf("invalid range end at %d", idx+1)
+			}
+			if hi < lo {
+				return fmt.Errorf("invalid range %c-%c", lo, hi)
+			}
+			idx = next
+		}
+		ranges = append(ranges, runeRange{lo: lo, hi: hi})
+	}
+
+	for _, r := range ranges {
+		for ch := r.lo; ch <= r.hi && ch < utf8.RuneSelf; ch++ {
+			c.ascii[ch/64] |= 1 << (ch % 64)
+		}
+		if r.hi >= utf8.RuneSelf {
+			if r.lo < utf8.RuneSelf {
+				r.lo = utf8.RuneSelf
+			}
+			c.ranges = append(c.ranges, r)
+		}
+	}
+	sort.Slice(c.ranges, func(i, j int) bool {
+		return c.ranges[i].lo < c.ranges[j].lo
+	})
+	c.ranges = mergeRuneRanges(c.ranges)
+
+	return nil
+}
+
+var perlClasses = map[rune][]runeRange{
+	'd': {{'0', '9'}},
+	'w': {{'0', '9'}, {'A', 'Z'}, {'_', '_'}, {'a', 'z'}},
+	's': {{'\t', '\n'}, {'\f', '\r'}, {' ', ' '}},
+}
+
+// parseItem parses a character or a class at idx of the class body.
+// Unicode classes are recorded on c, and an empty non-nil slice is returned for them.
+func (c *CharClass) parseItem(body []rune, idx int) (rune, []runeRange, int, error) {
+	if body[idx] != '\\' {
+		return body[idx], nil, idx + 1, nil
+	}
+	if idx+1 >= len(body) {
+		return 0, nil, 0, fmt.Errorf("trailing backslash")
+	}
+
+	escaped := body[idx+1]
+	next := idx + 2
+	switch escaped {
+	case 'n':
+		return '\n', nil, next, nil
+	case 'r':
+		return '\r', nil, next, nil
+	case 't':
+		return '\t', nil, next, nil
+	case 'f':
+		return '\f', nil, next, nil
+	case 'v':
+		return '\v', nil, next, nil
+	case 'd', 'w', 's':
+		return 0, perlClasses[escaped], next, nil
+	case 'x', 'u':
+		digits := 2
+		if escaped == 'u' {
+			digits = 4
+		}
+		if next+digits > len(body) {
+			return 0, nil, 0, fmt.Errorf("incomplete \\%c escape", escaped)
+		}
+		v, err := strconv.ParseUint(string(body[next:next+digits]), 16, 32)
+		if err != nil {
+			return 0, nil, 0, fmt.Errorf("invalid \\%c escape: %w", escaped, err)
+		}
+		return rune(v), nil, next + digits, nil
+	case 'p', 'P':
+		name, end, err := parseUnicodeClassName(body, next)
+		if err != nil {
+			return 0, nil, 0, err
+		}
+		table, ok := unicode.Categories[name]
+		if !ok {
+			table, ok = unicode.Scripts[name]
+		}
+		if !ok {
+			return 0, nil, 0, fmt.Errorf("unknown Unicode class %q", name)
+		}
+		c.classes = append(c.classes, unicodeClass{table: table, negated: escaped == 'P'})
+		return 0, []runeRange{}, end, nil
+	default:
+		return escaped, nil, next, nil
+	}
+}
+
+// parseUnicodeClassName parses the name of \pL or \p{Name} at idx.
+func parseUnicodeClassName(body []rune, idx int) (string, int, error) {
+	if idx >= len(body) {
+		return "", 0, fmt.Errorf("missing Unicode class name")
+	}
+	if body[idx] != '{' {
+		return string(body[idx]), idx + 1, nil
+	}
+	for end := idx + 1; end < len(body); end++ {
+		if body[end] == '}' {
+			return string(body[idx+1 : end]), end + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated Unicode class name")
+}
+
+func mergeRuneRanges(ranges []runeRange) []runeRange {
+	var merged []runeRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && r.lo <= merged[n-1].hi+1 {
+			if r.hi > merged[n-1].hi {
+				merged[n-1].hi = r.hi
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// contains reports if the character is in the set, regardless of the negation.
+func (c *CharClass) contains(ch rune) bool {
+	if ch < utf8.RuneSelf {
+		if c.ascii[ch/64]&(1<<(ch%64)) != 0 {
+			return true
+		}
+	} else {
+		idx := sort.Search(len(c.ranges), func(i int) bool {
+			return c.ranges[i].hi >= ch
+		})
+		if idx < len(c.ranges) && c.ranges[idx].lo <= ch {
+			return true
+		}
+	}
+	for _, class := range c.classes {
+		if unicode.Is(class.table, ch) != class.negated {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CharClass) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	if parseOpts.tokens != nil {
+		return c.matchToken(text, parseOpts, cache)
+	}
+	pos := parseOpts.pos
+	cache.examine(pos + 1)
+	ch, ok := cache.runeAt(text, pos)
+	if !ok || c.contains(ch) == c.negated {
+		return noMatch()
+	}
+	return matchedNode(cache.newNode(c, text, pos, pos+1))
+}
+
+func (c *CharClass) asRule() string {
+	return formatRuleRHSWithOptionalName(c.name, c.source)
+}
+
+// AnyChar matches any single character.
+type AnyChar struct {
+	expression
+
+	name string
+}
+
+var _ Expression = (*AnyChar)(nil)
+var _ exprImpl = (*AnyChar)(nil)
+
+func NewAnyChar(name string) *AnyChar {
+	rv := &AnyChar{name: name}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (a *AnyChar) exprName() string {
+	return a.name
+}
+
+func (a *AnyChar) setExprName(n string) {
+	a.name = n
+}
+
+func (a *AnyChar) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	cache.examine(pos + 1)
+	if _, ok := cache.runeAt(text, pos); !ok {
+		return noMatch()
+	}
+	return matchedNode(cache.newNode(a, text, pos, pos+1))
+}
+
+func (a *AnyChar) asRule() string {
+	return formatRuleRHSWithOptionalName(a.name, ".")
+}
+
+// EOF matches the end of input without consuming any text.
+type EOF struct {
+	expression
+
+	name string
+}
+
+var _ Expression = (*EOF)(nil)
+var _ exprImpl = (*EOF)(nil)
+
+func NewEOF() *EOF {
+	rv := &EOF{name: "EOF"}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (e *EOF) exprName() string {
+	return e.name
+}
+
+func (e *EOF) setExprName(n string) {
+	e.name = n
+}
+
+func (e *EOF) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	cache.examine(pos + 1)
+	if _, ok := cache.runeAt(text, pos); ok {
+		return noMatch()
+	}
+	return matchedNode(cache.newNode(e, text, pos, pos))
+}
+
+func (e *EOF) asRule() string {
+	return formatRuleRHSWithOptionalName(e.name, "<end of input>")
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CharClass(t *testing.T) {
+	cases := []struct {
+		source     string
+		matches    string
+		mismatches string
+	}{
+		{`[a-zA-Z_]`, "azAZ_", "09- é"},
+		{`[^"\\]`, "a'é\n", "\"\\"},
+		{`[\p{L}\d]`, "aé中09", "_ -"},
+		{`[\P{L}]`, "0_ ", "aé"},
+		{`[\p{Greek}-]`, "αΩ-", "a"},
+		{`[a-]`, "a-", "b"},
+		{`[\]\né]`, "]\né", "e["},
+		{`[\w\s]`, "aZ_9 \t", "-é"},
+		{`[à-ÿ]`, "àéÿ", "aĀ"},
+	}
+	for _, c := range cases {
+		charClass, err := NewCharClass("", c.source)
+		if !assert.NoError(t, err, c.source) {
+			continue
+		}
+		for _, ch := range c.matches {
+			node, err := charClass.Match(string(ch), createParseOpts())
+			assert.NoError(t, err, "%s should match %q", c.source, ch)
+			assert.Equal(t, string(ch), node.Text)
+		}
+		for _, ch := range c.mismatches {
+			_, err := charClass.Match(string(ch), createParseOpts())
+			assert.Error(t, err, "%s should not match %q", c.source, ch)
+		}
+		assert.Equal(t, c.source, charClass.asRule())
+	}
+
+	for _, source := range []string{`[]`, `[^]`, `[z-a]`, `[\p{Unknown}]`, `[\u12]`, `a-z`} {
+		_, err := NewCharClass("", source)
+		assert.Error(t, err, source)
+	}
+}
+
+func Test_AnyCharAndEOF(t *testing.T) {
+	expr := NewSequence("", []Expression{NewAnyChar(""), NewAnyChar(""), NewEOF()})
+
+	node, err := ParseWithExpression(expr, "é中")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, node.End)
+
+	_, err = ParseWithExpression(expr, "é")
+	assert.Error(t, err)
+
+	_, err = expr.Match("abc", createParseOpts())
+	assert.Error(t, err)
+}
@@ -3,12 +3,17 @@ package types
 import (
 	"fmt"
 	"strings"
+	"unicode/utf8"
 )
 
 type ErrParseFailed struct {
 	Text       string
 	Position   int
 	Expression Expression
+	// Tokens are the tokens parsed in token mode, of which Position is an index.
+	Tokens []Token
+	// Expected are the expressions expected at the farthest failed position, nil if unknown.
+	Expected []Expression
 }
 
 func newErrParseFailed(text string, position int, expression Expression) *ErrParseFailed {
@@ -19,26 +24,67 @@ func newErrParseFailed(text string, position int, expression Expression) *ErrPar
 	}
 }
 
+// describeRule returns the quoted name of the rule, or the rule itself when it's unnamed.
+func describeRule(expr Expression) string {
+	if expr.ExprName() == "" {
+		return expr.String()
+	}
+	return fmt.Sprintf("%q", expr.ExprName())
+}
+
 func (e *ErrParseFailed) Error() string {
-	var ruleName string
-	if e.Expression.ExprName() == "" {
-		ruleName = e.Expression.String()
-	} else {
-		ruleName = fmt.Sprintf("%q", e.Expression.ExprName())
+	if len(e.Expected) > 0 {
+		return fmt.Sprintf(
+			"expected %s at %s",
+			describeExpected(e.Expected),
+			e.at(),
+		)
+	}
+	if e.Expression.DisplayName() != "" {
+		return fmt.Sprintf(
+			"expected %s at %s",
+			e.Expression.DisplayName(),
+			e.at(),
+		)
 	}
-	line, column := e.LineAndColumn()
 
 	return fmt.Sprintf(
-		"rule %s didn't match at %q (line %d, column %d)",
-		ruleName,
-		sliceStringAsRuneSliceWithLength(e.Text, e.Position, 20),
-		line, column,
+		"rule %s didn't match at %s",
+		describeRule(e.Expression),
+		e.at(),
 	)
 }
 
+// at describes the position of the failure, by the text from it or by the token at it in token mode.
+func (e *ErrParseFailed) at() string {
+	if e.Tokens == nil {
+		line, column := e.LineAndColumn()
+		return fmt.Sprintf(
+			"%q (line %d, column %d)",
+			sliceStringAsRuneSliceWithLength(e.Text, e.Position, 20),
+			line, column,
+		)
+	}
+	if e.Position >= len(e.Tokens) {
+		return fmt.Sprintf("the end of tokens (token %d)", e.Position)
+	}
+	token := e.Tokens[e.Position]
+	return fmt.Sprintf("token %d %s %q (offset %d)", e.Position, token.Rule, token.Text, token.Start)
+}
+
+func (e *ErrParseFailed) parseFailed() *ErrParseFailed {
+	return e
+}
+
+// LineAndColumn returns the 1-based line and column of the rune position in the text.
 func (e *ErrParseFailed) LineAndColumn() (int, int) {
-	line := strings.Count(e.Text[:e.Position], "\n") + 1
-	column := e.Position - strings.LastIndex(e.Text[:e.Position], "\n")
+	position := e.Position
+	if n := utf8.RuneCountInString(e.Text); position > n {
+		position = n
+	}
+	before := sliceStringAsRuneSlice(e.Text, 0, position)
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
 
 	return line, column
 }
@@ -58,13 +104,11 @@ func newErrIncompleteParseFailed(
 }
 
 func (e *ErrIncompleteParseFailed) Error() string {
-	line, column := e.LineAndColumn()
 	return fmt.Sprintf(
 		"rule %q matched in its entirely, but it didn't consume all the text. "+
-			"The non-matching portion of the text begins with %q (line %d, column %d)",
+			"The non-matching portion of the text begins with %s",
 		e.Expression.ExprName(),
-		sliceStringAsRuneSliceWithLength(e.Text, e.Position, 20),
-		line, column,
+		e.at(),
 	)
 }
 
@@ -83,13 +127,102 @@ func newErrLeftRecursion(
 }
 
 func (e *ErrLeftRecursion) Error() string {
-	line, column := e.LineAndColumn()
-
 	return fmt.Sprintf(
-		"left recursion in rule %q at %q (line %d, column %d). "+
+		"left recursion in rule %q at %s. "+
 			"Please rewrite your grammar into a rule that does not use left recursion.",
 		e.Expression.ExprName(),
-		sliceStringAsRuneSliceWithLength(e.Text, e.Position, 20),
-		line, column,
+		e.at(),
 	)
 }
+
+type ErrIndentation struct {
+	ErrParseFailed
+
+	Reason string
+}
+
+func newErrIndentation(
+	text string,
+	position int,
+	expression Expression,
+	reason string,
+) *ErrIndentation {
+	return &ErrIndentation{
+		ErrParseFailed: *newErrParseFailed(text, position, expression),
+		Reason:         reason,
+	}
+}
+
+func (e *ErrIndentation) Error() string {
+	return fmt.Sprintf(
+		"indentation error in rule %q at %s: %s",
+		e.Expression.ExprName(),
+		e.at(),
+		e.Reason,
+	)
+}
+
+type ErrCutFailed struct {
+	ErrParseFailed
+
+	// Rule is the expression which committed to match after the cut.
+	Rule Expression
+}
+
+func newErrCutFailed(
+	text string,
+	position int,
+	expression Expression,
+	rule Expression,
+) *ErrCutFailed {
+	return &ErrCutFailed{
+		ErrParseFailed: *newErrParseFailed(text, position, expression),
+		Rule:           rule,
+	}
+}
+
+func (e *ErrCutFailed) Error() string {
+	return fmt.Sprintf(
+		"expected %s after the cut in rule %s at %s",
+		describeExpectedExpression(e.Expression),
+		describeRule(e.Rule),
+		e.at(),
+	)
+}
+
+// ErrInvalidGrammar is an error in the definition of a grammar at a rune position of the grammar text.
+type ErrInvalidGrammar struct {
+	// File is the path of the imported grammar file, empty for the grammar text given to NewGrammar.
+	File string
+	// Text is the grammar text.
+	Text string
+	// Position is the rune position of the invalid definition.
+	Position int
+	// Err is the error of the definition.
+	Err error
+}
+
+func (e *ErrInvalidGrammar) Error() string {
+	line, column := e.LineAndColumn()
+	if e.File != "" {
+		return fmt.Sprintf("%s (%s, line %d, column %d)", e.Err, e.File, line, column)
+	}
+	return fmt.Sprintf("%s (line %d, column %d)", e.Err, line, column)
+}
+
+func (e *ErrInvalidGrammar) Unwrap() error {
+	return e.Err
+}
+
+// LineAndColumn returns the 1-based line and column of the position in the grammar text.
+func (e *ErrInvalidGrammar) LineAndColumn() (int, int) {
+	position := e.Position
+	if n := utf8.RuneCountInString(e.Text); position > n {
+		position = n
+	}
+	before := sliceStringAsRuneSlice(e.Text, 0, position)
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+
+	return line, column
+}
@@ -0,0 +1,231 @@
+package types
+
+// EventHandler handles the events of parsing without building the parse tree.
+type EventHandler interface {
+	// Enter is called when a rule starts at the given rune position.
+	Enter(rule string, start int) error
+	// Exit is called when a rule ends with the matched rune range [start, end).
+	Exit(rule string, start int, end int) error
+}
+
+// parseWithLean enables lean mode on parsing.
+// In lean mode, matched nodes don't copy the text, and only keep the named nodes as children.
+func parseWithLean(lean bool) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.lean = lean
+	}
+}
+
+// leanNode drops the match of the node and splices the children of unnamed child nodes.
+// The text of the node isn't kept in lean mode, see nodeCache.nodeText.
+func leanNode(node *Node) {
+	node.Match = ""
+	for idx := range node.groups {
+		node.groups[idx].Text = ""
+	}
+	// the spliced children are not labeled in lean mode
+	node.labels = nil
+
+	hasUnnamedChild := false
+	for _, child := range node.Children {
+		if isLeanSpliced(child) {
+			hasUnnamedChild = true
+			break
+		}
+	}
+	if !hasUnnamedChild {
+		return
+	}
+
+	children := make([]*Node, 0, len(node.Children))
+	for _, child := range node.Children {
+		if isLeanSpliced(child) {
+			children = append(children, child.Children...)
+		} else {
+			children = append(children, child)
+		}
+	}
+	node.Children = children
+}
+
+// isLeanSpliced reports if the node should be spliced into its parent in lean mode.
+// Nodes of labeled failures are kept for collecting recovered failures.
+func isLeanSpliced(node *Node) bool {
+	if _, ok := node.Expression.(*Throw); ok {
+		return false
+	}
+	return node.Expression.ExprName() == ""
+}
+
+// eventEmitter reports the events of the committed matches while parsing, see ParseEventsWithExpression.
+type eventEmitter struct {
+	handler EventHandler
+	// recovering is true if the recovered failures should be collected.
+	recovering bool
+	// rules are the entered rules of the committed matches, from the outermost.
+	rules []Expression
+	// failures are the recovered failures in the reported nodes.
+	failures []*ErrLabeledFailure
+}
+
+// isReported reports if the matches of the expression are reported as events.
+// The inline rules are spliced into their parents, as they are in the parse tree.
+func isReported(expr Expression) bool {
+	return expr.ExprName() != "" && !expr.Annotations().Has(AnnotationInline)
+}
+
+func (em *eventEmitter) enter(expr Expression, start int) error {
+	em.rules = append(em.rules, expr)
+	return em.handler.Enter(expr.ExprName(), start)
+}
+
+func (em *eventEmitter) exit(expr Expression, start int, end int) error {
+	em.rules = em.rules[:len(em.rules)-1]
+	return em.handler.Exit(expr.ExprName(), start, end)
+}
+
+// emitNode reports the events of the committed node and its descendants.
+func (em *eventEmitter) emitNode(text string, node *Node) error {
+	if em.recovering {
+		var rule Expression
+		if len(em.rules) > 0 {
+			rule = em.rules[len(em.rules)-1]
+		}
+		em.failures = collectRecoveredFailures(text, node, rule, em.failures)
+	}
+	return emitEvents(node, em.handler)
+}
+
+// commitsMembers reports if the committed match of the expression commits its members,
+// which report their own events while matching, instead of the events reported for the whole node.
+// The members of a committed sequence are committed, as the parse fails if any of them fails.
+// The repetitions of a committed quantifier are committed once they match.
+func (e *expression) commitsMembers() bool {
+	if e.annotations.Has(AnnotationToken) {
+		return false
+	}
+	switch e.impl.(type) {
+	case *Sequence, *Quantifier:
+		return true
+	default:
+		return false
+	}
+}
+
+// matchCommitted matches the expression which is committed, of which match is never backtracked
+// unless the parse fails. The events of the match are reported as soon as it's committed.
+// The committed matches are never attempted again, so they aren't memoized.
+func (e *expression) matchCommitted(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	if _, ok := cache.get(e, pos, parseOpts.lexical); ok || !e.commitsMembers() {
+		// it's matched as a whole, or it was attempted before being committed, e.g. in a lookahead
+		matchResult := e.matchWithCache(text, parseOpts.withCommitted(false), cache)
+		if matchResult.isMatchedNode() {
+			if err := parseOpts.events.emitNode(text, matchResult.Node); err != nil {
+				return matchFailed(err)
+			}
+		}
+		return matchResult
+	}
+
+	reported := isReported(e)
+	if reported {
+		if err := parseOpts.events.enter(e, pos); err != nil {
+			return matchFailed(err)
+		}
+	}
+
+	// the entry in progress detects the left recursion
+	stateVersion := cache.stateVersion
+	cache.set(e, pos, parseOpts.lexical, stateVersion, &memoEntry{node: nodeInProgress})
+	matchResult := e.match(text, parseOpts, cache)
+	cache.unset(e, pos, parseOpts.lexical, stateVersion)
+
+	if reported && matchResult.isMatchedNode() {
+		if err := parseOpts.events.exit(e, pos, matchResult.Node.End); err != nil {
+			return matchFailed(err)
+		}
+	}
+	return matchResult
+}
+
+// commitRepetition reports the events of the committed repetition of a quantifier,
+// and drops the memoized results before it, which are never used again.
+func commitRepetition(text string, node *Node, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	if err := parseOpts.events.emitNode(text, node); err != nil {
+		return matchFailed(err)
+	}
+	cache.dropBefore(node.End)
+	return nil
+}
+
+func emitEvents(node *Node, handler EventHandler) error {
+	rule := node.Expression.ExprName()
+	if rule != "" {
+		if err := handler.Enter(rule, node.Start); err != nil {
+			return err
+		}
+	}
+
+	for _, child := range node.Children {
+		if err := emitEvents(child, handler); err != nil {
+			return err
+		}
+	}
+
+	if rule != "" {
+		if err := handler.Exit(rule, node.Start, node.End); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ParseEventsWithExpression parses the given text with the given expression,
+// and reports the matched rules to the handler instead of returning the parse tree.
+//
+// Only the rules of committed matches are reported, backtracked attempts are never reported.
+// A match is committed when it can't be backtracked without failing the parse: the members
+// of the committed sequences, from the expression, and each repetition of the committed quantifiers
+// once it matches. The events of a committed match are reported as soon as it's committed,
+// and the memoized results before it are dropped, so a text like a list of statements is
+// validated in a single pass, without keeping the matched statements. The other matches,
+// e.g. the alternatives of a one-of expression, are reported when their committed ancestor matches.
+//
+// As the events are reported while parsing, the events before the failure are reported
+// when the parse fails, and the rules entered by the failed match are never exited.
+func ParseEventsWithExpression(expr Expression, text string, handler EventHandler, opts ...ParseOption) error {
+	emitter := &eventEmitter{handler: handler}
+	opts = append([]ParseOption{parseWithLean(true)}, opts...)
+	opts = append(opts, parseWithEvents(emitter))
+	emitter.recovering = len(createParseOpts(opts...).recovery) > 0
+
+	_, err := ParseWithExpression(expr, text, opts...)
+	if err != nil {
+		return err
+	}
+	if len(emitter.failures) > 0 {
+		// recovered failures are reported after the events
+		return &ErrRecovered{Failures: emitter.failures}
+	}
+	return nil
+}
+
+// parseWithEvents reports the committed matches to the emitter, see ParseEventsWithExpression.
+func parseWithEvents(emitter *eventEmitter) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.events = emitter
+		opts.committed = true
+	}
+}
+
+// withCommitted returns a copy of the options for matching a committed or uncommitted expression.
+func (opts *ParseOptions) withCommitted(committed bool) *ParseOptions {
+	if opts.committed == committed {
+		return opts
+	}
+	newOpts := *opts
+	newOpts.committed = committed
+	return &newOpts
+}
@@ -0,0 +1,60 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingEventHandler struct {
+	events []string
+}
+
+func (h *recordingEventHandler) Enter(rule string, start int) error {
+	h.events = append(h.events, fmt.Sprintf("enter %s %d", rule, start))
+	return nil
+}
+
+func (h *recordingEventHandler) Exit(rule string, start int, end int) error {
+	h.events = append(h.events, fmt.Sprintf("exit %s %d %d", rule, start, end))
+	return nil
+}
+
+func Test_ParseEventsWithExpression_Streaming(t *testing.T) {
+	word := NewRegex("word", regexp2.MustCompile(`^[a-z]+`, regexp2.RE2))
+	statement := NewSequence("statement", []Expression{word, NewLiteral(";")})
+	statements := NewZeroOrMore("statements", statement)
+
+	// the committed statements are reported before the parse fails on the incomplete statement
+	handler := &recordingEventHandler{}
+	err := ParseEventsWithExpression(statements, "a;bc;d", handler)
+	assert.Error(t, err)
+	assert.Equal(
+		t,
+		[]string{
+			"enter statements 0",
+			"enter statement 0",
+			"enter word 0",
+			"exit word 0 1",
+			"exit statement 0 2",
+			"enter statement 2",
+			"enter word 2",
+			"exit word 2 4",
+			"exit statement 2 5",
+			"exit statements 0 5",
+		},
+		handler.events,
+	)
+
+	// the memoized results of the committed statements are dropped
+	cache := newNodeCache()
+	handler = &recordingEventHandler{}
+	err = ParseEventsWithExpression(statements, "a;bc;d;", handler, parseWithMemo(cache))
+	assert.NoError(t, err)
+	assert.Equal(t, "exit statements 0 7", handler.events[len(handler.events)-1])
+	for key := range cache.entries {
+		assert.GreaterOrEqual(t, key.pos, 5)
+	}
+}
@@ -1,55 +1,17 @@
 package types
 
 import (
+	"errors"
 	"fmt"
-	"hash/fnv"
+	"io/fs"
 	"math"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
 	"github.com/dlclark/regexp2"
 )
 
-func hash(d []byte) uint64 {
-	// TODO: use murmur3?
-	h := fnv.New64a()
-	h.Write(d)
-	return h.Sum64()
-}
-
-type nodePosCache map[int]*Node
-
-var nodeInProgress = new(Node)
-
-type nodeCache map[uint64]nodePosCache
-
-func (c nodeCache) get(expr Expression, pos int) *Node {
-	if c == nil {
-		return nil
-	}
-
-	if posCache, ok := c[expr.hash()]; ok {
-		if node, ok := posCache[pos]; ok {
-			return node
-		}
-	}
-
-	return nil
-}
-
-func (c nodeCache) set(expr Expression, pos int, node *Node) {
-	if c == nil {
-		return
-	}
-
-	exprHash := expr.hash()
-	if _, ok := c[exprHash]; !ok {
-		c[exprHash] = nodePosCache{}
-	}
-
-	c[exprHash][pos] = node
-}
-
 type matchResult struct {
 	Node *Node
 	Err  error
@@ -132,6 +94,24 @@ func joinExpressionsAsRule(exprs []Expression, sep string) string {
 	return sb.String()
 }
 
+func joinLabeledExpressionsAsRule(exprs []Expression, labels []string, sep string) string {
+	if labels == nil {
+		return joinExpressionsAsRule(exprs, sep)
+	}
+
+	var sb strings.Builder
+	for i, expr := range exprs {
+		if i > 0 {
+			sb.WriteString(sep)
+		}
+		if labels[i] != "" {
+			sb.WriteString(labels[i] + ":")
+		}
+		sb.WriteString(joinExpressionAsRule(expr))
+	}
+	return sb.String()
+}
+
 // Expression represents a parsimonious expression.
 type Expression interface {
 	fmt.Stringer
@@ -141,26 +121,48 @@ type Expression interface {
 	// SetExprName sets the name of the expression.
 	// TODO: maybe we should get rid of this?
 	SetExprName(string)
+	// DisplayName returns the human readable name of the expression for error messages.
+	// It's empty if the expression doesn't declare one.
+	DisplayName() string
+	// SetDisplayName sets the human readable name of the expression.
+	SetDisplayName(string)
+	// Annotations returns the rule annotations of the expression.
+	Annotations() Annotations
+	// SetAnnotations sets the rule annotations of the expression.
+	SetAnnotations(Annotations)
 	// Match matches the expression against the given text at the given rune position.
 	Match(text string, parseOpts *ParseOptions) (*Node, error)
 
 	// matchWithCache matches the expression against the given text at the given rune position. (internal usage)
-	matchWithCache(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult
-	// hash returns a hash value for the expression. (internal usage)
-	hash() uint64
+	matchWithCache(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult
 }
 
 // ParseOptions represents options for parsing.
 type ParseOptions struct {
-	pos   int
-	debug bool
+	pos         int
+	debug       bool
+	incremental bool
+	memo        *nodeCache
+	lean        bool
+	funcs       Funcs
+	state       State
+	recovery    map[string]Expression
+	skip        Expression
+	lexical     bool
+	fsys        fs.FS
+	tokens      []Token
+	// events reports the committed matches in event mode, see ParseEventsWithExpression.
+	events *eventEmitter
+	// committed is true if the current match attempt is committed in event mode.
+	committed bool
+	// silent is true if the failed attempts aren't expected in the parse failures, see nodeCache.expect.
+	silent bool
 }
 
 func (opts *ParseOptions) withPos(newPos int) *ParseOptions {
-	return &ParseOptions{
-		pos:   newPos,
-		debug: opts.debug,
-	}
+	newOpts := *opts
+	newOpts.pos = newPos
+	return &newOpts
 }
 
 func (opts *ParseOptions) debugf(format string, args ...interface{}) { //nolint:unused
@@ -187,21 +189,77 @@ func ParseWithDebug(debug bool) func(*ParseOptions) {
 	}
 }
 
+// ParseWithIncremental enables incremental mode on parsing.
+// In incremental mode, the parsed tree retains the memo table of the parse,
+// which can be reused by Grammar.Reparse after editing the tree with Node.Edit.
+func ParseWithIncremental(incremental bool) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.incremental = incremental
+	}
+}
+
+// parseWithMemo sets the memo table to reuse on parsing.
+func parseWithMemo(memo *nodeCache) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.memo = memo
+	}
+}
+
 // ParseWithExpression parses the given text with the given expression.
 func ParseWithExpression(expr Expression, text string, opts ...ParseOption) (*Node, error) {
 	parseOpts := createParseOpts(opts...)
 
-	node, err := expr.Match(text, parseOpts)
+	cache := parseOpts.memo
+	if cache == nil {
+		cache = newNodeCache()
+	}
+	// parsing always starts from the initial state
+	cache.stateVersion = 0
+	cache.indents = nil
+	cache.lean = parseOpts.lean
+	cache.resetExpected()
+	var node *Node
+	var err error
+	if parseOpts.shouldSkip() && !isLexicalRule(expr) {
+		node, err = skipLeadingAndTrailingTrivia(expr, text, parseOpts, cache)
+	} else {
+		node, err = matchWithMemo(expr, text, parseOpts, cache)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if textLen := utf8.RuneCountInString(text); node.End < textLen {
+	if textLen := cache.size(text); node.End < textLen {
+		if cache.farthest > node.End {
+			// the failure after the end of the match tells why the parse stopped
+			return nil, newErrFarthestFailure(text, node.End, expr, cache)
+		}
 		return nil, newErrIncompleteParseFailed(text, node.End, expr)
 	}
+	if parseOpts.incremental {
+		node.memo = cache
+	}
+	// the recovered failures are collected while reporting the events in event mode
+	if len(parseOpts.recovery) > 0 && parseOpts.events == nil {
+		if failures := collectRecoveredFailures(text, node, nil, nil); len(failures) > 0 {
+			return node, &ErrRecovered{Failures: failures}
+		}
+	}
 
 	return node, nil
 }
 
+func matchWithMemo(expr Expression, text string, parseOpts *ParseOptions, cache *nodeCache) (*Node, error) {
+	result := expr.matchWithCache(text, parseOpts, cache)
+	switch {
+	case result.isMatchedNode():
+		return result.Node, nil
+	case result.isMatchFailed():
+		return nil, result.Err
+	default:
+		return nil, newErrFarthestFailure(text, parseOpts.pos, expr, cache)
+	}
+}
+
 type withResolveRefs interface {
 	Expression
 
@@ -233,13 +291,14 @@ func resolveRefsForMany(vs []Expression, rules map[string]Expression) ([]Express
 type exprImpl interface {
 	exprName() string
 	setExprName(s string)
-	identity() []byte // for hashing
-	uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult
+	uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult
 	asRule() string
 }
 
 type expression struct {
-	impl exprImpl
+	impl        exprImpl
+	displayName string
+	annotations Annotations
 }
 
 func (e *expression) ExprName() string {
@@ -250,42 +309,120 @@ func (e *expression) SetExprName(n string) {
 	e.impl.setExprName(n)
 }
 
+func (e *expression) DisplayName() string {
+	return e.displayName
+}
+
+func (e *expression) SetDisplayName(n string) {
+	e.displayName = n
+}
+
+func (e *expression) Annotations() Annotations {
+	return e.annotations
+}
+
+func (e *expression) SetAnnotations(a Annotations) {
+	e.annotations = a
+}
+
 func (e *expression) Match(text string, parseOpts *ParseOptions) (*Node, error) {
-	cache := nodeCache{}
-	result := e.matchWithCache(text, parseOpts, cache)
-	switch {
-	case result.isMatchedNode():
-		return result.Node, nil
-	case result.isMatchFailed():
-		return nil, result.Err
-	default:
-		return nil, newErrParseFailed(text, parseOpts.pos, e)
-	}
+	return matchWithMemo(e, text, parseOpts, newNodeCache())
 }
 
-func (e *expression) matchWithCache(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
-	node := cache.get(e, parseOpts.pos)
-	if node == nil {
-		cache.set(e, parseOpts.pos, nodeInProgress)
-		matchResult := e.impl.uncachedMatch(text, parseOpts, cache)
+func (e *expression) matchWithCache(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	if parseOpts.shouldSkip() && isLexicalRule(e) {
+		parseOpts = parseOpts.withLexical()
+	}
+	if parseOpts.committed {
+		return e.matchCommitted(text, parseOpts, cache)
+	}
+	if e.annotations.Has(AnnotationNoMemo) {
+		return e.matchWithoutMemo(text, parseOpts, cache)
+	}
+	pos := parseOpts.pos
+	stateVersion := cache.stateVersion
+	entry, ok := cache.get(e, pos, parseOpts.lexical)
+	if ok {
+		cache.examine(entry.extent)
+		if entry.node != nil && entry.node != nodeInProgress {
+			cache.switchState(parseOpts.state, entry.stateVersion)
+		}
+		if entry.node == nil {
+			// the memoized failure might be attempted silently
+			cache.expect(e, parseOpts)
+		}
+	} else {
+		entry = &memoEntry{node: nodeInProgress}
+		cache.set(e, pos, parseOpts.lexical, stateVersion, entry)
+
+		examined := cache.examined
+		cache.examined = pos
+		matchResult := e.match(text, parseOpts, cache)
+		entry.extent = cache.examined
+		cache.examine(examined)
+
 		if matchResult.isMatchFailed() {
+			cache.unset(e, pos, parseOpts.lexical, stateVersion)
 			return matchResult
 		}
-		node = matchResult.Node
-		cache.set(e, parseOpts.pos, node)
+		entry.node = matchResult.Node
+		entry.stateVersion = cache.stateVersion
+		cache.saveState(parseOpts.state)
 	}
-	if node == nodeInProgress {
-		return matchFailed(newErrLeftRecursion(text, parseOpts.pos, e))
+	if entry.node == nodeInProgress {
+		return matchFailed(newErrLeftRecursion(text, pos, e))
 	}
-	if node == nil {
+	if entry.node == nil {
 		return noMatch()
 	}
 
-	return matchedNode(node)
+	return matchedNode(entry.node)
 }
 
-func (e *expression) hash() uint64 {
-	return hash(e.impl.identity())
+// matchWithoutMemo matches the expression without memoizing the result,
+// while the attempt in progress is still tracked for detecting left recursions.
+func (e *expression) matchWithoutMemo(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	key := memoKey{expr: e, pos: parseOpts.pos, lexical: parseOpts.lexical, stateVersion: cache.stateVersion}
+	if _, ok := cache.matching[key]; ok {
+		return matchFailed(newErrLeftRecursion(text, parseOpts.pos, e))
+	}
+	cache.matching[key] = struct{}{}
+	defer delete(cache.matching, key)
+
+	return e.match(text, parseOpts, cache)
+}
+
+// match matches the expression without looking up the memo table.
+func (e *expression) match(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	stateVersion := cache.stateVersion
+	implOpts := parseOpts
+	if e.displayName != "" {
+		// the rule with a display name is expected as a whole
+		implOpts = parseOpts.withSilent()
+	}
+	matchResult := e.impl.uncachedMatch(text, implOpts, cache)
+	switch {
+	case matchResult.isMatchFailed():
+		if failure, ok := matchResult.Err.(*ErrLabeledFailure); ok && failure.Rule == nil && e.ExprName() != "" {
+			failure.Rule = e
+		}
+	case matchResult.isNoMatch():
+		// roll back the user state changed by the failed attempt
+		cache.switchState(parseOpts.state, stateVersion)
+		cache.expect(e, parseOpts)
+	default:
+		if e.annotations.Has(AnnotationToken) {
+			// the labels go with the children, while the capture groups of a regex token are its own
+			matchResult.Node.Children = nil
+			matchResult.Node.labels = nil
+			matchResult.Node.Trivia = nil
+		}
+		if parseOpts.lean {
+			leanNode(matchResult.Node)
+		}
+	}
+
+	return matchResult
 }
 
 func (e *expression) String() string {
@@ -302,6 +439,7 @@ type Literal struct {
 	literal          string
 	literalRuneCount int
 	name             string
+	caseInsensitive  bool
 }
 
 var _ Expression = (*Literal)(nil)
@@ -322,10 +460,22 @@ func NewLiteral(literal string) *Literal {
 	return NewLiteralWithName("", literal)
 }
 
+// NewCaseInsensitiveLiteral creates a literal which matches the text with Unicode simple case folding.
+func NewCaseInsensitiveLiteral(literal string) *Literal {
+	rv := NewLiteral(literal)
+	rv.caseInsensitive = true
+	return rv
+}
+
 func (l *Literal) GetLiteral() string {
 	return l.literal
 }
 
+// IsCaseInsensitive reports if the literal matches the text regardless of case.
+func (l *Literal) IsCaseInsensitive() bool {
+	return l.caseInsensitive
+}
+
 func (l *Literal) exprName() string {
 	return l.name
 }
@@ -334,18 +484,22 @@ func (l *Literal) setExprName(n string) {
 	l.name = n
 }
 
-func (l *Literal) identity() []byte {
-	return []byte("literal:" + l.name + ":" + l.literal)
-}
-
-func (l *Literal) uncachedMatch(text string, parseOpts *ParseOptions, _ nodeCache) *matchResult {
+func (l *Literal) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	if parseOpts.tokens != nil {
+		return l.matchToken(text, parseOpts, cache)
+	}
 	pos := parseOpts.pos
-	if utf8.RuneCountInString(text) < pos+l.literalRuneCount {
+	runes := cache.runesOf(text)
+	if len(runes) < pos+l.literalRuneCount {
+		cache.examine(len(runes) + 1)
 		return noMatch()
 	}
+	cache.examine(pos + l.literalRuneCount)
 
-	if sliceStringAsRuneSlice(text, pos, pos+l.literalRuneCount) == l.literal {
-		node := newNode(l, text, pos, pos+l.literalRuneCount)
+	matched := string(runes[pos : pos+l.literalRuneCount])
+	// simple case folding maps a rune to a rune, so the matched text has the same rune count
+	if matched == l.literal || (l.caseInsensitive && strings.EqualFold(matched, l.literal)) {
+		node := cache.newNode(l, text, pos, pos+l.literalRuneCount)
 		return matchedNode(node)
 	}
 
@@ -353,10 +507,11 @@ func (l *Literal) uncachedMatch(text string, parseOpts *ParseOptions, _ nodeCach
 }
 
 func (l *Literal) asRule() string {
-	return formatRuleRHSWithOptionalName(
-		l.name,
-		fmt.Sprintf("%q", l.literal),
-	)
+	rhs := fmt.Sprintf("%q", l.literal)
+	if l.caseInsensitive {
+		rhs += "i"
+	}
+	return formatRuleRHSWithOptionalName(l.name, rhs)
 }
 
 type Sequence struct {
@@ -364,11 +519,13 @@ type Sequence struct {
 
 	name    string
 	members []Expression
+	labels  []string
 }
 
 var _ Expression = (*Sequence)(nil)
 var _ exprImpl = (*Sequence)(nil)
 var _ withResolveRefs = (*Sequence)(nil)
+var _ withUnresolveRefs = (*Sequence)(nil)
 
 func NewSequence(name string, members []Expression) *Sequence {
 	rv := &Sequence{
@@ -380,6 +537,12 @@ func NewSequence(name string, members []Expression) *Sequence {
 	return rv
 }
 
+// SetLabels sets the labels of the members, which are recorded on the matched nodes.
+// The labels are aligned with the members, unlabeled members have empty labels.
+func (s *Sequence) SetLabels(labels []string) {
+	s.labels = labels
+}
+
 func (s *Sequence) exprName() string {
 	return s.name
 }
@@ -388,19 +551,36 @@ func (s *Sequence) setExprName(n string) {
 	s.name = n
 }
 
-func (s *Sequence) identity() []byte {
-	return []byte("sequence:" + s.name)
-}
-
-func (s *Sequence) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
+func (s *Sequence) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
 	curPos := parseOpts.pos
 	children := make([]*Node, 0, len(s.members))
+	var trivia []*Node
+	committed := false
 	for idx := range s.members {
+		if _, ok := s.members[idx].(*Cut); ok {
+			committed = true
+			if !parseOpts.incremental {
+				// the text before the cut is never matched again unless an enclosing expression backtracks,
+				// so the memoized results are dropped, while they're kept for reparsing in incremental mode
+				cache.dropBefore(curPos)
+			}
+		}
+		if idx > 0 && parseOpts.shouldSkip() {
+			skipped, skippedPos, failed := skipTrivia(text, parseOpts.withPos(curPos), cache)
+			if failed != nil {
+				return failed
+			}
+			trivia = append(trivia, skipped...)
+			curPos = skippedPos
+		}
 		matchResult := s.members[idx].matchWithCache(text, parseOpts.withPos(curPos), cache)
 		if matchResult.isMatchFailed() {
 			return matchResult
 		}
 		if matchResult.isNoMatch() {
+			if committed {
+				return matchFailed(newErrCutFailed(text, curPos, s.members[idx], s))
+			}
 			return matchResult
 		}
 		node := matchResult.Node
@@ -408,7 +588,8 @@ func (s *Sequence) uncachedMatch(text string, parseOpts *ParseOptions, cache nod
 		curPos += node.End - node.Start
 	}
 
-	node := newNodeWithChildren(s, text, parseOpts.pos, curPos, children)
+	node := cache.newNodeWithLabeledChildren(s, text, parseOpts.pos, curPos, children, s.labels)
+	node.Trivia = append(node.Trivia, trivia...)
 	return matchedNode(node)
 }
 
@@ -422,12 +603,20 @@ func (s *Sequence) ResolveRefs(refs map[string]Expression) (Expression, error) {
 	return s, nil
 }
 
+func (s *Sequence) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *s
+	rv.expression.impl = &rv
+	u.copies[s] = &rv
+	rv.members = u.unresolveMany(s.members)
+	return &rv
+}
+
 func (s *Sequence) asRule() string {
 	return formatRuleRHSWithOptionalName(
 		s.exprName(),
 		fmt.Sprintf(
 			"(%s)",
-			joinExpressionsAsRule(s.members, " "),
+			joinLabeledExpressionsAsRule(s.members, s.labels, " "),
 		),
 	)
 }
@@ -437,11 +626,13 @@ type OneOf struct {
 
 	name    string
 	members []Expression
+	labels  []string
 }
 
 var _ Expression = (*OneOf)(nil)
 var _ exprImpl = (*OneOf)(nil)
 var _ withResolveRefs = (*OneOf)(nil)
+var _ withUnresolveRefs = (*OneOf)(nil)
 
 func NewOneOf(name string, members []Expression) *OneOf {
 	rv := &OneOf{
@@ -457,6 +648,12 @@ func (of *OneOf) SetMembers(members []Expression) {
 	of.members = members
 }
 
+// SetLabels sets the labels of the members, which are recorded on the matched nodes.
+// The labels are aligned with the members, unlabeled members have empty labels.
+func (of *OneOf) SetLabels(labels []string) {
+	of.labels = labels
+}
+
 func (of *OneOf) exprName() string {
 	return of.name
 }
@@ -465,18 +662,20 @@ func (of *OneOf) setExprName(n string) {
 	of.name = n
 }
 
-func (of *OneOf) identity() []byte {
-	return []byte("oneOf:" + of.name)
-}
-
-func (of *OneOf) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
+func (of *OneOf) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
 	for idx := range of.members {
 		matchResult := of.members[idx].matchWithCache(text, parseOpts, cache)
 		if matchResult.isMatchFailed() {
 			return matchResult
 		}
 		if matchResult.isMatchedNode() {
-			oneOfNode := newNodeWithChildren(of, text, parseOpts.pos, matchResult.Node.End, []*Node{matchResult.Node})
+			var labels []string
+			if of.labels != nil {
+				labels = []string{of.labels[idx]}
+			}
+			oneOfNode := cache.newNodeWithLabeledChildren(
+				of, text, parseOpts.pos, matchResult.Node.End, []*Node{matchResult.Node}, labels,
+			)
 			return matchedNode(oneOfNode)
 		}
 	}
@@ -494,12 +693,20 @@ func (of *OneOf) ResolveRefs(refs map[string]Expression) (Expression, error) {
 	return of, nil
 }
 
+func (of *OneOf) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *of
+	rv.expression.impl = &rv
+	u.copies[of] = &rv
+	rv.members = u.unresolveMany(of.members)
+	return &rv
+}
+
 func (of *OneOf) asRule() string {
 	return formatRuleRHSWithOptionalName(
 		of.exprName(),
 		fmt.Sprintf(
 			"(%s)",
-			joinExpressionsAsRule(of.members, " / "),
+			joinLabeledExpressionsAsRule(of.members, of.labels, " / "),
 		),
 	)
 }
@@ -515,6 +722,7 @@ type Lookahead struct {
 var _ Expression = (*Lookahead)(nil)
 var _ exprImpl = (*Lookahead)(nil)
 var _ withResolveRefs = (*Lookahead)(nil)
+var _ withUnresolveRefs = (*Lookahead)(nil)
 
 func NewLookahead(name string, member Expression, negative bool) *Lookahead {
 	rv := &Lookahead{
@@ -539,22 +747,27 @@ func (l *Lookahead) setExprName(n string) {
 	l.name = n
 }
 
-func (l *Lookahead) identity() []byte {
-	return []byte("lookahead:" + l.name)
-}
-
-func (l *Lookahead) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
-	matchResult := l.member.matchWithCache(text, parseOpts, cache)
+func (l *Lookahead) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	stateVersion := cache.stateVersion
+	// the lookahead is expected as a whole, instead of its member
+	matchResult := l.member.matchWithCache(text, parseOpts.withSilent(), cache)
+	var cutFailed *ErrCutFailed
+	if matchResult.isMatchFailed() && errors.As(matchResult.Err, &cutFailed) {
+		// a cut commits the alternatives inside the lookahead only, the failure is a plain no match of it
+		matchResult = noMatch()
+	}
 	if matchResult.isMatchFailed() {
 		return matchResult
 	}
+	// lookahead never changes the user state
+	cache.switchState(parseOpts.state, stateVersion)
 
 	pos := parseOpts.pos
 	switch {
 	case matchResult.isNoMatch() && l.negative:
-		return matchedNode(newNode(l, text, pos, pos))
+		return matchedNode(cache.newNode(l, text, pos, pos))
 	case matchResult.isMatchedNode() && !l.negative:
-		return matchedNode(newNode(l, text, pos, pos))
+		return matchedNode(cache.newNode(l, text, pos, pos))
 	default:
 		return noMatch()
 	}
@@ -570,6 +783,14 @@ func (l *Lookahead) ResolveRefs(refs map[string]Expression) (Expression, error)
 	return l, nil
 }
 
+func (l *Lookahead) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *l
+	rv.expression.impl = &rv
+	u.copies[l] = &rv
+	rv.member = u.unresolve(l.member)
+	return &rv
+}
+
 func (l *Lookahead) asRule() string {
 	prefix := "&"
 	if l.negative {
@@ -598,6 +819,7 @@ type Quantifier struct {
 var _ Expression = (*Quantifier)(nil)
 var _ exprImpl = (*Quantifier)(nil)
 var _ withResolveRefs = (*Quantifier)(nil)
+var _ withUnresolveRefs = (*Quantifier)(nil)
 
 func newQuantifier(name string, member Expression, min float64, max float64) *Quantifier {
 	rv := &Quantifier{
@@ -631,44 +853,65 @@ func (q *Quantifier) setExprName(n string) {
 	q.name = n
 }
 
-func (q *Quantifier) identity() []byte {
-	// FIXME: use pointer address as identity?
-	b := fmt.Sprintf(
-		"quantifier:%s:%d",
-		q.name, q.member.hash(),
-	)
-
-	return []byte(b)
-}
-
-func (q *Quantifier) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
+func (q *Quantifier) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
 	curPos := parseOpts.pos
 	children := make([]*Node, 0)
-	size := utf8.RuneCountInString(text)
-	for curPos < size && float64(len(children)) < q.max {
-		matchResult := q.member.matchWithCache(text, parseOpts.withPos(curPos), cache)
+	var trivia []*Node
+	// the repetitions of a committed quantifier are reported instead of kept, see ParseEventsWithExpression
+	committed := parseOpts.committed
+	memberOpts := parseOpts.withCommitted(false)
+	repetitions := 0
+	size := cache.size(text)
+	// the member is attempted at the end of input too, so its failure there is expected in the parse failures
+	for float64(repetitions) < q.max {
+		memberPos := curPos
+		var skipped []*Node
+		if repetitions > 0 && parseOpts.shouldSkip() {
+			var failed *matchResult
+			skipped, memberPos, failed = skipTrivia(text, memberOpts.withPos(curPos), cache)
+			if failed != nil {
+				return failed
+			}
+		}
+		matchResult := q.member.matchWithCache(text, memberOpts.withPos(memberPos), cache)
 		if matchResult.isMatchFailed() {
 			return matchResult
 		}
 		if matchResult.isNoMatch() {
+			// the trivia is left to the following expressions
 			break
 		}
+		curPos = memberPos
 		node := matchResult.Node
 		//parseOpts.debugf("[%s] matched new node: %s %q\n", q, node, node.Text)
-		children = append(children, node)
+		repetitions++
+		if committed {
+			if failed := commitRepetition(text, node, parseOpts, cache); failed != nil {
+				return failed
+			}
+		} else {
+			trivia = append(trivia, skipped...)
+			children = append(children, node)
+		}
 		nodeMatchedLength := node.End - node.Start
-		if nodeMatchedLength == 0 && float64(len(children)) >= q.min {
+		if nodeMatchedLength == 0 && float64(repetitions) >= q.min {
 			// This is a zero-length match (lookahead), so we need to advance the cursor after reaching minimum
 			break
 		}
 		curPos += nodeMatchedLength
 	}
 
-	if float64(len(children)) < q.min {
+	if curPos >= size {
+		// the end of input was examined to stop the loop
+		cache.examine(size + 1)
+	}
+
+	if float64(repetitions) < q.min {
 		return noMatch()
 	}
 
-	node := newNodeWithChildren(q, text, parseOpts.pos, curPos, children)
+	node := cache.newNodeWithChildren(q, text, parseOpts.pos, curPos, children)
+	node.Trivia = append(node.Trivia, trivia...)
 	return matchedNode(node)
 }
 
@@ -682,6 +925,14 @@ func (q *Quantifier) ResolveRefs(refs map[string]Expression) (Expression, error)
 	return q, nil
 }
 
+func (q *Quantifier) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *q
+	rv.expression.impl = &rv
+	u.copies[q] = &rv
+	rv.member = u.unresolve(q.member)
+	return &rv
+}
+
 func (q *Quantifier) asRule() string {
 	var quantifier string
 	switch {
@@ -710,8 +961,15 @@ type Regex struct {
 
 	name string
 	re   *regexp2.Regexp
+	// tokenRe matches the whole text of a token in token mode, nil to match with re.
+	tokenRe *regexp2.Regexp
+	// groupNames are the names of the capture groups by their numbers from 1, nil to use the names from re.
+	groupNames []string
 }
 
+// NewRegex creates a regex expression.
+// In token mode, the leftmost match of the regex should cover the whole text of the token,
+// e.g. ~"a|ab" doesn't match the token "ab". See NewRegexWithTokenRegex for matching the whole text.
 func NewRegex(name string, re *regexp2.Regexp) *Regex {
 	rv := &Regex{
 		name: name,
@@ -722,6 +980,22 @@ func NewRegex(name string, re *regexp2.Regexp) *Regex {
 	return rv
 }
 
+// NewRegexWithTokenRegex creates a regex expression which matches the text of a token with tokenRe in token mode.
+// tokenRe should be anchored at both ends of the text, e.g. ^(?:pattern)\z, so any match covers the whole text.
+func NewRegexWithTokenRegex(name string, re *regexp2.Regexp, tokenRe *regexp2.Regexp) *Regex {
+	rv := NewRegex(name, re)
+	rv.tokenRe = tokenRe
+
+	return rv
+}
+
+// SetGroupNames sets the names of the capture groups by their numbers from 1, empty for unnamed groups.
+// It names the groups of a regex of which named groups are written as unnamed groups,
+// so the groups are numbered from left to right as in Python, rather than after the unnamed groups.
+func (r *Regex) SetGroupNames(names []string) {
+	r.groupNames = names
+}
+
 func (r *Regex) exprName() string {
 	return r.name
 }
@@ -730,22 +1004,25 @@ func (r *Regex) setExprName(n string) {
 	r.name = n
 }
 
-func (r *Regex) identity() []byte {
-	return []byte("re:" + r.name + ":" + r.re.String())
-}
-
-func (r *Regex) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
+func (r *Regex) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	if parseOpts.tokens != nil {
+		return r.matchToken(text, parseOpts, cache)
+	}
 	pos := parseOpts.pos
-	textToMatch := sliceStringAsRuneSlice(text, pos, -1)
+	runes := cache.runesOf(text)
 
-	//parseOpts.debugf("[%s] trying regex (%s) match at pos %d %q\n",r, r.re,pos, textToMatch)
+	//parseOpts.debugf("[%s] trying regex (%s) match at pos %d\n",r, r.re,pos)
 
-	matchGroups, err := r.re.FindStringMatch(textToMatch)
+	// the runes are matched in place, rather than converting the rest of the text on each attempt
+	matchGroups, err := r.re.FindRunesMatch(runes[pos:])
 	if err != nil {
 		//parseOpts.debugf("[%s] regex match failed: %s (pos=%d)\n", r, err, pos)
 
 		return matchFailed(err)
 	}
+	// regexp2 doesn't tell how far the text was examined, and a backtracking match
+	// may have examined any text after its end, so we assume the end of input was examined.
+	cache.examine(len(runes) + 1)
 	if matchGroups == nil {
 		//parseOpts.debugf("[%s] regex match failed: no match (pos=%d)\n", r, pos)
 
@@ -761,10 +1038,38 @@ func (r *Regex) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCa
 	matchedEnd := pos + match.Index + match.Length
 
 	//parseOpts.debugf("[%s] regex matched: (pos=%d)\n", r, pos)
-	node := newRegexNode(r, text, pos, matchedEnd, match.String())
+	node := cache.newRegexNode(r, text, pos, matchedEnd, match.String(), r.groups(matchGroups, pos))
 	return matchedNode(node)
 }
 
+// groups converts the capture groups other than the whole match, of which positions are
+// relative to the matching position pos.
+func (r *Regex) groups(m *regexp2.Match, pos int) []Group {
+	matchGroups := m.Groups()
+	if len(matchGroups) < 2 {
+		return nil
+	}
+
+	groups := make([]Group, 0, len(matchGroups)-1)
+	for idx, g := range matchGroups[1:] {
+		group := Group{Name: g.Name, Start: -1, End: -1}
+		if r.groupNames != nil {
+			group.Name = strconv.Itoa(idx + 1)
+			if idx < len(r.groupNames) && r.groupNames[idx] != "" {
+				group.Name = r.groupNames[idx]
+			}
+		}
+		if len(g.Captures) > 0 {
+			// as in Python, the last capture of a repeated group is kept
+			group.Text = g.String()
+			group.Start = pos + g.Index
+			group.End = pos + g.Index + g.Length
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
 func (r *Regex) asRule() string {
 	// TODO: record options
 	return formatRuleRHSWithOptionalName(
@@ -778,11 +1083,14 @@ type LazyReference struct {
 
 	name          string
 	referenceName string
+	// args are the arguments of the reference to a parameterized rule, nil for references to rules.
+	args []Expression
 }
 
 var _ Expression = (*LazyReference)(nil)
 var _ exprImpl = (*LazyReference)(nil)
 var _ withResolveRefs = (*LazyReference)(nil)
+var _ withUnresolveRefs = (*LazyReference)(nil)
 
 func NewLazyReference(referenceName string) *LazyReference {
 	rv := &LazyReference{
@@ -794,6 +1102,20 @@ func NewLazyReference(referenceName string) *LazyReference {
 	return rv
 }
 
+// NewLazyReferenceWithArgs creates a reference to the parameterized rule with the arguments,
+// which is expanded with the arguments on resolving. See RuleTemplate.
+func NewLazyReferenceWithArgs(referenceName string, args []Expression) *LazyReference {
+	rv := NewLazyReference(referenceName)
+	rv.args = args
+
+	return rv
+}
+
+// ReferenceName returns the name of the referenced rule.
+func (r *LazyReference) ReferenceName() string {
+	return r.referenceName
+}
+
 func (r *LazyReference) exprName() string {
 	return r.name
 }
@@ -802,23 +1124,21 @@ func (r *LazyReference) setExprName(n string) {
 	r.name = n
 }
 
-func (r *LazyReference) identity() []byte {
-	return []byte("lazy_reference:" + r.referenceName)
-}
-
-func (r *LazyReference) uncachedMatch(text string, parseOpts *ParseOptions, cache nodeCache) *matchResult {
+func (r *LazyReference) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
 	return matchFailed(fmt.Errorf("lazy reference %q is not resolved", r.referenceName))
 }
 
 func (r *LazyReference) ResolveRefs(refs map[string]Expression) (Expression, error) {
-	seenExprs := make(map[string]struct{})
+	seenRefs := make(map[string]struct{})
 	current := r
 	for {
-		currentIdentity := string(current.identity())
-		if _, exists := seenExprs[currentIdentity]; exists {
+		if current.args != nil {
+			return current.instantiate(refs)
+		}
+		if _, exists := seenRefs[current.referenceName]; exists {
 			return nil, fmt.Errorf("circular reference detected for %q", r.referenceName)
 		} else {
-			seenExprs[currentIdentity] = struct{}{}
+			seenRefs[current.referenceName] = struct{}{}
 		}
 		resolved, exists := refs[current.referenceName]
 		if !exists {
@@ -828,10 +1148,82 @@ func (r *LazyReference) ResolveRefs(refs map[string]Expression) (Expression, err
 			current = resolvedReference
 			continue
 		}
+		if _, ok := resolved.(*RuleTemplate); ok {
+			return nil, fmt.Errorf("parameterized rule %q is referenced without arguments", current.referenceName)
+		}
 		return resolved, nil
 	}
 }
 
+// instantiate expands the referenced parameterized rule with the arguments resolved by refs.
+func (r *LazyReference) instantiate(refs map[string]Expression) (Expression, error) {
+	resolved, exists := refs[r.referenceName]
+	if !exists {
+		return nil, fmt.Errorf("lazy reference %q is not resolved", r.referenceName)
+	}
+	template, ok := resolved.(*RuleTemplate)
+	if !ok {
+		return nil, fmt.Errorf("rule %q is not parameterized", r.referenceName)
+	}
+
+	args, err := resolveRefsForMany(r.args, refs)
+	if err != nil {
+		return nil, err
+	}
+	return template.instantiate(args, refs)
+}
+
+func (r *LazyReference) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *r
+	rv.expression.impl = &rv
+	u.copies[r] = &rv
+	if r.args != nil {
+		rv.args = u.unresolveMany(r.args)
+	} else if bound, ok := u.params[r.referenceName]; ok {
+		rv.referenceName = bound
+	}
+	return &rv
+}
+
 func (r *LazyReference) asRule() string {
+	if r.args != nil {
+		return fmt.Sprintf("<LazyReference to %s(%s)>", r.referenceName, joinExpressionsAsRule(r.args, ", "))
+	}
 	return fmt.Sprintf("<LazyReference to %s>", r.referenceName)
 }
+
+// Cut commits a sequence to its current match.
+// After a cut, a failure of the following members is a hard error instead of a backtrack,
+// so the enclosing alternatives won't be tried.
+type Cut struct {
+	expression
+
+	name string
+}
+
+var _ Expression = (*Cut)(nil)
+var _ exprImpl = (*Cut)(nil)
+
+func NewCut() *Cut {
+	rv := &Cut{}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (c *Cut) exprName() string {
+	return c.name
+}
+
+func (c *Cut) setExprName(n string) {
+	c.name = n
+}
+
+func (c *Cut) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	return matchedNode(cache.newNode(c, text, pos, pos))
+}
+
+func (c *Cut) asRule() string {
+	return formatRuleRHSWithOptionalName(c.exprName(), "~>")
+}
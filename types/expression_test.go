@@ -3,6 +3,7 @@ package types
 import (
 	"testing"
 
+	"github.com/dlclark/regexp2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,7 +18,27 @@ func Test_Expression_Match(t *testing.T) {
 		expr := NewLiteralWithName("greeting", "hello")
 		assertMatchAsNode(
 			t, expr, "hello",
-			newNode(expr, "hello", 0, 5),
+			newNodeCache().newNode(expr, "hello", 0, 5),
+		)
+	})
+
+	t.Run("CaseInsensitiveLiteral", func(t *testing.T) {
+		expr := NewCaseInsensitiveLiteral("straße")
+		assertMatchAsNode(
+			t, expr, "STRAßE",
+			newNodeCache().newNode(expr, "STRAßE", 0, 6),
+		)
+		assert.Equal(t, `"straße"i`, expr.asRule())
+
+		// simple case folding doesn't expand ß to ss
+		_, err := expr.Match("STRASSE", createParseOpts())
+		assert.Error(t, err)
+
+		// the Kelvin sign folds to k
+		expr = NewCaseInsensitiveLiteral("kelvin")
+		assertMatchAsNode(
+			t, expr, "\u212Aelvin",
+			newNodeCache().newNode(expr, "\u212Aelvin", 0, 6),
 		)
 	})
 
@@ -32,14 +53,44 @@ func Test_Expression_Match(t *testing.T) {
 		text := "heighho"
 		assertMatchAsNode(
 			t, expr, text,
-			newNodeWithChildren(
+			newNodeCache().newNodeWithChildren(
 				expr,
 				text, 0, 7,
 				[]*Node{
-					newNode(NewLiteral("heigh"), text, 0, 5),
-					newNode(NewLiteral("ho"), text, 5, 7),
+					newNodeCache().newNode(NewLiteral("heigh"), text, 0, 5),
+					newNodeCache().newNode(NewLiteral("ho"), text, 5, 7),
 				},
 			),
 		)
 	})
+
+	t.Run("RegexGroups", func(t *testing.T) {
+		// (?P<a>x)(y)(z)? with the named group written as an unnamed one
+		expr := NewRegex("", regexp2.MustCompile(`^(x)(y)(z)?`, regexp2.None))
+		expr.SetGroupNames([]string{"a", "", ""})
+		node, err := expr.Match("xy", createParseOpts())
+		assert.NoError(t, err)
+		groups := node.Groups()
+		if assert.Len(t, groups, 3) {
+			assert.Equal(t, Group{Name: "a", Text: "x", Start: 0, End: 1}, groups[0])
+			assert.Equal(t, Group{Name: "2", Text: "y", Start: 1, End: 2}, groups[1])
+			assert.Equal(t, Group{Name: "3", Start: -1, End: -1}, groups[2])
+		}
+		// the named groups are found by their numbers too
+		assert.Equal(t, "x", node.Group("1").Text)
+		assert.Equal(t, "x", node.Group("a").Text)
+		assert.Equal(t, "y", node.Group("2").Text)
+		assert.Nil(t, node.Group("3"))
+		assert.Nil(t, node.Group("4"))
+
+		// without the group names, the regexp2 numbering is kept
+		expr = NewRegex("", regexp2.MustCompile(`^(?<a>x)(y)`, regexp2.None))
+		node, err = expr.Match("xy", createParseOpts())
+		assert.NoError(t, err)
+		groups = node.Groups()
+		if assert.Len(t, groups, 2) {
+			assert.Equal(t, Group{Name: "1", Text: "y", Start: 1, End: 2}, groups[0])
+			assert.Equal(t, Group{Name: "a", Text: "x", Start: 0, End: 1}, groups[1])
+		}
+	})
 }
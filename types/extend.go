@@ -0,0 +1,285 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// RulesParser parses the rules of a grammar text into expressions named by the rules,
+// of which references are left as lazy references.
+type RulesParser func(text string) (map[string]Expression, error)
+
+// rulesParser is the registered parser of grammar texts for Grammar.Extend.
+var rulesParser RulesParser
+
+// RegisterRulesParser registers the parser of grammar texts for Grammar.Extend.
+// It's called by the package implementing the grammar syntax, which depends on this package.
+func RegisterRulesParser(parser RulesParser) {
+	rulesParser = parser
+}
+
+// Extend returns a new grammar with the rules defined in the text, which override the rules
+// of the same names or add new rules. References of all the rules are resolved again,
+// so the rules referencing an overridden rule match the new one. The grammar is unchanged.
+func (g *Grammar) Extend(text string) (*Grammar, error) {
+	if rulesParser == nil {
+		return nil, fmt.Errorf("no grammar text parser is registered")
+	}
+
+	rules, err := rulesParser(text)
+	if err != nil {
+		return nil, err
+	}
+	return g.WithRules(rules)
+}
+
+// WithRules returns a new grammar with the expressions, which override the rules of the same names
+// or add new rules. The expressions are named by their keys, and can reference the rules by lazy references.
+// References of all the rules are resolved again, so the rules referencing an overridden rule match the new one.
+// The grammar is unchanged.
+func (g *Grammar) WithRules(rules map[string]Expression) (*Grammar, error) {
+	u := newRefsUnresolver(g.rules)
+
+	// definitions are the rules with lazy references, which are kept unresolved
+	definitions := make(map[string]Expression, len(g.rules)+len(rules))
+	for name, rule := range g.rules {
+		if definition, ok := g.definitions[name]; ok {
+			definitions[name] = definition
+			continue
+		}
+		if ruleName := u.ruleNames[rule]; ruleName != name {
+			// an alias of another rule
+			definitions[name] = NewLazyReference(ruleName)
+			continue
+		}
+		definitions[name] = u.unresolveRule(rule)
+	}
+	for name, rule := range rules {
+		if ruleName, ok := u.ruleNames[rule]; ok {
+			// a rule of the grammar is used as is, and keeps its name
+			definitions[name] = NewLazyReference(ruleName)
+			continue
+		}
+		// the caller's expression is copied before being renamed
+		copied := newRefsUnresolver(nil).copyRule(rule)
+		copied.SetExprName(name)
+		definitions[name] = copied
+	}
+
+	// resolve copies of the definitions
+	copier := newRefsUnresolver(nil)
+	newRules := make(map[string]Expression, len(definitions))
+	names := make([]string, 0, len(definitions))
+	for name, definition := range definitions {
+		newRules[name] = copier.unresolveRule(definition)
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		resolved, err := ResolveRefsFor(newRules[name], newRules)
+		if err != nil {
+			return nil, fmt.Errorf("resolve refs for %q: %w", name, err)
+		}
+		newRules[name] = resolved
+	}
+
+	rebind := func(expr Expression) (Expression, error) {
+		if name, ok := u.ruleNames[expr]; ok {
+			return newRules[name], nil
+		}
+		return ResolveRefsFor(u.unresolve(expr), newRules)
+	}
+
+	rv := *g
+	rv.rules = newRules
+	rv.definitions = definitions
+	defaultRule, err := rebind(g.defaultRule)
+	if err != nil {
+		return nil, fmt.Errorf("resolve refs for the default rule: %w", err)
+	}
+	rv.defaultRule = defaultRule
+	if g.skip != nil {
+		if rv.skip, err = rebind(g.skip); err != nil {
+			return nil, fmt.Errorf("resolve refs for the skip expression: %w", err)
+		}
+	}
+	if g.recovery != nil {
+		rv.recovery = make(map[string]Expression, len(g.recovery))
+		for label, rule := range g.recovery {
+			if rv.recovery[label], err = rebind(rule); err != nil {
+				return nil, fmt.Errorf("resolve refs for recovering label %q: %w", label, err)
+			}
+		}
+	}
+	return &rv, nil
+}
+
+// withUnresolveRefs is implemented by the expressions with members.
+type withUnresolveRefs interface {
+	Expression
+
+	// unresolveRefs returns a copy of the expression, of which members referencing rules are
+	// turned back into lazy references, and the other members are copied by u.
+	unresolveRefs(u *refsUnresolver) Expression
+}
+
+// refsUnresolver copies the expressions of a grammar, with the resolved references to rules
+// turned back into lazy references, so the copies can be resolved with different rules.
+// Without rules, it copies the expressions as they are.
+type refsUnresolver struct {
+	// ruleNames are the names of the rule expressions.
+	ruleNames map[Expression]string
+	// copies are the copied expressions, to keep the shared members shared.
+	copies map[Expression]Expression
+	// params maps the parameters of a template to the names bound to the arguments, see RuleTemplate.instantiate.
+	params map[string]string
+}
+
+func newRefsUnresolver(rules map[string]Expression) *refsUnresolver {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ruleNames := make(map[Expression]string, len(rules))
+	for _, name := range names {
+		if rules[name].ExprName() == name {
+			ruleNames[rules[name]] = name
+		}
+	}
+	// the rules not named by their names, e.g. aliases, are named by the first name
+	for _, name := range names {
+		if _, ok := ruleNames[rules[name]]; !ok {
+			ruleNames[rules[name]] = name
+		}
+	}
+
+	return &refsUnresolver{
+		ruleNames: ruleNames,
+		copies:    make(map[Expression]Expression),
+	}
+}
+
+// unresolve returns the lazy reference to the rule if the expression is a rule,
+// or a copy of the expression otherwise.
+func (u *refsUnresolver) unresolve(expr Expression) Expression {
+	if name, ok := u.ruleNames[expr]; ok {
+		return NewLazyReference(name)
+	}
+	return u.unresolveRule(expr)
+}
+
+// unresolveRule returns a copy of the rule expression, expressions without members are shared.
+func (u *refsUnresolver) unresolveRule(expr Expression) Expression {
+	if copied, ok := u.copies[expr]; ok {
+		return copied
+	}
+	if e, ok := expr.(withUnresolveRefs); ok {
+		return e.unresolveRefs(u)
+	}
+	return expr
+}
+
+func (u *refsUnresolver) unresolveMany(exprs []Expression) []Expression {
+	rv := make([]Expression, len(exprs))
+	for idx, expr := range exprs {
+		rv[idx] = u.unresolve(expr)
+	}
+	return rv
+}
+
+// copyRule returns a copy of the rule expression, which can be renamed without changing the rule.
+// Unlike unresolveRule, the expressions without members are copied too.
+func (u *refsUnresolver) copyRule(expr Expression) Expression {
+	if _, ok := expr.(withUnresolveRefs); ok {
+		return u.unresolveRule(expr)
+	}
+	return copyLeaf(expr)
+}
+
+// copyLeaf returns a shallow copy of the expression without members.
+func copyLeaf(expr Expression) Expression {
+	switch e := expr.(type) {
+	case *Literal:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *Regex:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *CharClass:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *AnyChar:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *EOF:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *Indentation:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *Cut:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *Predicate:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *CustomMatcher:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *Operation:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	case *RuleTemplate:
+		rv := *e
+		rv.expression.impl = &rv
+		return &rv
+	default:
+		return expr
+	}
+}
+
+// NamespacedRules returns copies of the rules of the names, keyed and named by the names
+// prefixed with the namespace, like ns.rule, e.g. for importing the rules into another grammar.
+// The references between the copies are lazy references to the prefixed names, and the aliases
+// are lazy references to the prefixed names of the aliased rules. The grammar is unchanged.
+func (g *Grammar) NamespacedRules(namespace string, ruleNames []string) map[string]Expression {
+	prefix := namespace + "."
+	prefixed := make(map[string]Expression, len(ruleNames))
+	for _, name := range ruleNames {
+		if rule, ok := g.rules[name]; ok {
+			prefixed[prefix+name] = rule
+		}
+	}
+
+	u := newRefsUnresolver(prefixed)
+	for name, rule := range prefixed {
+		// the rules are named by their prefixed names, while the aliases reference them
+		if prefix+rule.ExprName() == name {
+			u.ruleNames[rule] = name
+		}
+	}
+	rules := make(map[string]Expression, len(prefixed))
+	for name, rule := range prefixed {
+		if ruleName := u.ruleNames[rule]; ruleName != name {
+			// an alias of another rule
+			rules[name] = NewLazyReference(ruleName)
+			continue
+		}
+		copied := u.copyRule(rule)
+		copied.SetExprName(name)
+		rules[name] = copied
+	}
+	return rules
+}
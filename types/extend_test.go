@@ -0,0 +1,25 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Grammar_WithRules(t *testing.T) {
+	greeting := NewLiteralWithName("greeting", "hello")
+	grammar := NewGrammar(map[string]Expression{"greeting": greeting}, greeting)
+
+	// the caller's expression is named by its key in the grammar, without being renamed
+	hi := NewLiteralWithName("hi", "hi")
+	extended, err := grammar.WithRules(map[string]Expression{"short_greeting": hi})
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", hi.ExprName())
+	rule, ok := extended.GetRule("short_greeting")
+	assert.True(t, ok)
+	assert.Equal(t, "short_greeting", rule.ExprName())
+
+	tree, err := extended.ParseWithRule("short_greeting", "hi")
+	assert.NoError(t, err)
+	assert.Equal(t, "short_greeting", tree.Expression.ExprName())
+}
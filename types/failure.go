@@ -0,0 +1,198 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseWithRecovery sets the recovery expressions by failure labels on parsing.
+//
+// When a labeled failure is thrown and a recovery expression is set for its label,
+// the failure is recorded and the recovery expression is matched in place of the failed expression.
+// The parsing then continues, and the recorded failures are returned as ErrRecovered along with the tree:
+// when the parse recovers from failures, both the tree and the *ErrRecovered error are returned,
+// so the callers should check the error with errors.As before discarding the tree.
+func ParseWithRecovery(recovery map[string]Expression) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.recovery = recovery
+	}
+}
+
+// Throw throws a labeled failure when its member doesn't match.
+// The failure is a hard error unless it's recovered.
+type Throw struct {
+	expression
+
+	name    string
+	member  Expression
+	label   string
+	message string
+}
+
+var _ Expression = (*Throw)(nil)
+var _ exprImpl = (*Throw)(nil)
+var _ withResolveRefs = (*Throw)(nil)
+var _ withUnresolveRefs = (*Throw)(nil)
+
+func NewThrow(name string, member Expression, label string, message string) *Throw {
+	rv := &Throw{
+		name:    name,
+		member:  member,
+		label:   label,
+		message: message,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+// Label returns the failure label.
+func (t *Throw) Label() string {
+	return t.label
+}
+
+// Message returns the failure message.
+func (t *Throw) Message() string {
+	return t.message
+}
+
+func (t *Throw) exprName() string {
+	return t.name
+}
+
+func (t *Throw) setExprName(n string) {
+	t.name = n
+}
+
+func (t *Throw) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	matchResult := t.member.matchWithCache(text, parseOpts, cache)
+	if !matchResult.isNoMatch() {
+		if matchResult.isMatchedNode() {
+			node := cache.newNodeWithChildren(t, text, pos, matchResult.Node.End, []*Node{matchResult.Node})
+			return matchedNode(node)
+		}
+		return matchResult
+	}
+
+	if recovery, ok := parseOpts.recovery[t.label]; ok && t.label != "" {
+		recoveryResult := recovery.matchWithCache(text, parseOpts, cache)
+		if recoveryResult.isMatchedNode() {
+			node := cache.newNodeWithChildren(t, text, pos, recoveryResult.Node.End, []*Node{recoveryResult.Node})
+			node.recovered = true
+			return matchedNode(node)
+		}
+		if recoveryResult.isMatchFailed() {
+			return recoveryResult
+		}
+	}
+
+	return matchFailed(newErrLabeledFailure(text, pos, t.member, t.label, t.message))
+}
+
+func (t *Throw) ResolveRefs(refs map[string]Expression) (Expression, error) {
+	newMember, err := ResolveRefsFor(t.member, refs)
+	if err != nil {
+		return nil, err
+	}
+
+	t.member = newMember
+	return t, nil
+}
+
+func (t *Throw) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *t
+	rv.expression.impl = &rv
+	u.copies[t] = &rv
+	rv.member = u.unresolve(t.member)
+	return &rv
+}
+
+func (t *Throw) asRule() string {
+	var failureLabel string
+	switch {
+	case t.label != "" && t.message != "":
+		failureLabel = fmt.Sprintf("%s:%q", t.label, t.message)
+	case t.label != "":
+		failureLabel = t.label
+	default:
+		failureLabel = fmt.Sprintf("%q", t.message)
+	}
+
+	return formatRuleRHSWithOptionalName(
+		t.exprName(),
+		fmt.Sprintf("%s^%s", joinExpressionAsRule(t.member), failureLabel),
+	)
+}
+
+// collectRecoveredFailures collects the recovered labeled failures in the tree.
+func collectRecoveredFailures(text string, node *Node, rule Expression, failures []*ErrLabeledFailure) []*ErrLabeledFailure {
+	if node.Expression.ExprName() != "" {
+		rule = node.Expression
+	}
+	if t, ok := node.Expression.(*Throw); ok && node.recovered {
+		failure := newErrLabeledFailure(text, node.Start, t.member, t.label, t.message)
+		failure.Rule = rule
+		failures = append(failures, failure)
+	}
+
+	for _, child := range node.Children {
+		failures = collectRecoveredFailures(text, child, rule, failures)
+	}
+
+	return failures
+}
+
+type ErrLabeledFailure struct {
+	ErrParseFailed
+
+	// Label is the label of the failure.
+	Label string
+	// Message is the custom message of the failure.
+	Message string
+	// Rule is the nearest named rule which the failure was thrown from.
+	Rule Expression
+}
+
+func newErrLabeledFailure(
+	text string,
+	position int,
+	expression Expression,
+	label string,
+	message string,
+) *ErrLabeledFailure {
+	return &ErrLabeledFailure{
+		ErrParseFailed: *newErrParseFailed(text, position, expression),
+		Label:          label,
+		Message:        message,
+	}
+}
+
+func (e *ErrLabeledFailure) Error() string {
+	message := e.Message
+	if message == "" {
+		message = fmt.Sprintf("expected %s", describeExpectedExpression(e.Expression))
+	}
+	if e.Rule != nil {
+		message = fmt.Sprintf("%s in rule %s", message, describeRule(e.Rule))
+	}
+	return fmt.Sprintf(
+		"%s at %s",
+		message,
+		e.at(),
+	)
+}
+
+// ErrRecovered reports the labeled failures recovered in a parsing.
+type ErrRecovered struct {
+	Failures []*ErrLabeledFailure
+}
+
+func (e *ErrRecovered) Error() string {
+	messages := make([]string, 0, len(e.Failures))
+	for _, failure := range e.Failures {
+		messages = append(messages, failure.Error())
+	}
+
+	return fmt.Sprintf("recovered from %d failures: %s", len(e.Failures), strings.Join(messages, "; "))
+}
@@ -0,0 +1,29 @@
+package types
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseWithRecovery_Lean(t *testing.T) {
+	// the unnamed member of the throw is spliced in lean mode, leaving the single named child
+	keyword := NewLiteralWithName("keyword", "a")
+	throw := NewThrow("", NewSequence("", []Expression{keyword}), "missing_keyword", "")
+	recovery := map[string]Expression{"missing_keyword": NewRegex("", regexp2.MustCompile(`^[^;]*`, regexp2.RE2))}
+	statement := NewSequence("statement", []Expression{throw, NewLiteral(";")})
+
+	tree, err := ParseWithExpression(statement, "a;", ParseWithRecovery(recovery), parseWithLean(true))
+	assert.NoError(t, err)
+	assert.NotNil(t, tree)
+
+	tree, err = ParseWithExpression(statement, "b;", ParseWithRecovery(recovery), parseWithLean(true))
+	var recovered *ErrRecovered
+	assert.True(t, errors.As(err, &recovered))
+	assert.Len(t, recovered.Failures, 1)
+	assert.Equal(t, "missing_keyword", recovered.Failures[0].Label)
+	// the tree is returned along with the recovered failures
+	assert.NotNil(t, tree)
+}
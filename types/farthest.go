@@ -0,0 +1,118 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The parse failures are reported at the farthest position where the expected expressions failed,
+// like "expected a number, "-" or "(" at ...". The expected expressions are the terminals
+// and the rules with display names, while the rules with display names are expected as a whole,
+// instead of the expressions inside them.
+
+// withSilent returns a copy of the options of which failed attempts aren't expected,
+// e.g. the attempts inside lookaheads.
+func (opts *ParseOptions) withSilent() *ParseOptions {
+	if opts.silent {
+		return opts
+	}
+	newOpts := *opts
+	newOpts.silent = true
+	return &newOpts
+}
+
+// isExpected reports if the failed attempts of the expression are reported as expected.
+func isExpected(expr Expression) bool {
+	if expr.DisplayName() != "" {
+		return true
+	}
+	switch e := expr.(type) {
+	case *Literal:
+		return e.literal != ""
+	case *Regex, *CharClass, *AnyChar, *CustomMatcher:
+		return true
+	default:
+		return false
+	}
+}
+
+// expect records the failed attempt of the expression at the position,
+// if it's not before the farthest failed position.
+func (c *nodeCache) expect(e *expression, parseOpts *ParseOptions) {
+	expr := e.impl.(Expression)
+	if parseOpts.silent || !isExpected(expr) {
+		return
+	}
+
+	pos := parseOpts.pos
+	switch {
+	case pos > c.farthest:
+		c.farthest = pos
+		c.expected = []Expression{expr}
+	case pos == c.farthest:
+		for _, expected := range c.expected {
+			if expected == expr {
+				return
+			}
+		}
+		c.expected = append(c.expected, expr)
+	}
+}
+
+// resetExpected clears the failed attempts recorded by the previous parse.
+func (c *nodeCache) resetExpected() {
+	c.farthest = -1
+	c.expected = nil
+}
+
+// newErrFarthestFailure creates the parse failure of the expression at the farthest failed position
+// after pos, with the expressions expected there. It's positioned at pos if no failure is recorded after pos.
+func newErrFarthestFailure(text string, pos int, expression Expression, cache *nodeCache) *ErrParseFailed {
+	err := newErrParseFailed(text, pos, expression)
+	if cache.farthest >= pos && len(cache.expected) > 0 {
+		err.Position = cache.farthest
+		err.Expected = append([]Expression(nil), cache.expected...)
+	}
+	return err
+}
+
+// describeExpected describes the expected expressions, like `a number, "-" or "("`.
+func describeExpected(exprs []Expression) string {
+	seen := make(map[string]struct{}, len(exprs))
+	descriptions := make([]string, 0, len(exprs))
+	for _, expr := range exprs {
+		description := describeExpectedExpression(expr)
+		if _, ok := seen[description]; ok {
+			continue
+		}
+		seen[description] = struct{}{}
+		descriptions = append(descriptions, description)
+	}
+	sort.Strings(descriptions)
+
+	if len(descriptions) == 1 {
+		return descriptions[0]
+	}
+	last := len(descriptions) - 1
+	return fmt.Sprintf("%s or %s", strings.Join(descriptions[:last], ", "), descriptions[last])
+}
+
+// describeExpectedExpression describes the expected expression by its display name,
+// by the text of a literal, or by the rule name.
+func describeExpectedExpression(expr Expression) string {
+	if expr.DisplayName() != "" {
+		return expr.DisplayName()
+	}
+	if l, ok := expr.(*Literal); ok {
+		description := fmt.Sprintf("%q", l.literal)
+		if l.caseInsensitive {
+			description += "i"
+		}
+		return description
+	}
+	if expr.ExprName() != "" {
+		return fmt.Sprintf("%q", expr.ExprName())
+	}
+	return expr.(exprImpl).asRule()
+}
@@ -0,0 +1,34 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseWithExpression_FarthestFailure(t *testing.T) {
+	number := NewRegex("number", regexp2.MustCompile(`^[0-9]+`, regexp2.RE2))
+	number.SetDisplayName("a number")
+	value := NewOneOf("value", []Expression{number, NewLiteral("true"), NewLiteral("false")})
+	// the keyword in the lookahead isn't expected
+	name := NewSequence("name", []Expression{
+		NewNot(NewLiteral("if")),
+		NewRegex("", regexp2.MustCompile(`^[a-z]+`, regexp2.RE2)),
+	})
+	assignment := NewSequence("assignment", []Expression{name, NewLiteral("="), value})
+	assignments := NewOneOrMore("assignments", NewSequence("", []Expression{assignment, NewLiteral(";")}))
+
+	_, err := ParseWithExpression(assignments, "x=?;")
+	assert.IsType(t, &ErrParseFailed{}, err)
+	assert.Equal(t, 2, err.(*ErrParseFailed).Position)
+	assert.Equal(t, `expected "false", "true" or a number at "?;" (line 1, column 3)`, err.Error())
+
+	_, err = ParseWithExpression(assignments, "1=2;")
+	assert.Equal(t, `expected ~^[a-z]+ at "1=2;" (line 1, column 1)`, err.Error())
+
+	// the failure after the end of the match tells why the repetitions stopped
+	_, err = ParseWithExpression(assignments, "x=1;y=;")
+	assert.IsType(t, &ErrParseFailed{}, err)
+	assert.Equal(t, 6, err.(*ErrParseFailed).Position)
+}
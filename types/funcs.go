@@ -0,0 +1,223 @@
+package types
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MatchContext is passed to the Go functions called from grammars.
+type MatchContext struct {
+	// Text is the full text being parsed. In token mode, it has a U+FFFC placeholder for each token,
+	// the tokens are in Tokens.
+	Text string
+	// Pos is the rune position of the current match attempt, or the token index in token mode.
+	Pos int
+	// State is the user state set by ParseWithState, nil if not set.
+	State State
+	// Tokens are the tokens being parsed in token mode, nil otherwise.
+	Tokens []Token
+
+	cache *nodeCache
+}
+
+func newMatchContext(text string, parseOpts *ParseOptions, cache *nodeCache) *MatchContext {
+	// take a snapshot before the function changes the state
+	cache.saveState(parseOpts.state)
+
+	return &MatchContext{
+		Text:   text,
+		Pos:    parseOpts.pos,
+		State:  parseOpts.state,
+		Tokens: parseOpts.tokens,
+		cache:  cache,
+	}
+}
+
+// PredicateFunc reports if the parsing should continue at the current position.
+type PredicateFunc func(ctx *MatchContext) (bool, error)
+
+// MatcherFunc matches the text at the current position.
+// It returns the number of runes consumed and whether the text is matched.
+type MatcherFunc func(ctx *MatchContext) (int, bool, error)
+
+// Funcs are the Go functions callable from grammars by name.
+type Funcs struct {
+	// Predicates are called by `&{name}` and `!{name}` expressions.
+	Predicates map[string]PredicateFunc
+	// Matchers are called by `@name` expressions.
+	Matchers map[string]MatcherFunc
+}
+
+// ParseWithFuncs sets the Go functions callable from grammars on parsing.
+func ParseWithFuncs(funcs Funcs) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.funcs = funcs
+	}
+}
+
+// Predicate is a zero-length expression which calls a Go function to decide whether it matches.
+type Predicate struct {
+	expression
+
+	name     string
+	funcName string
+	negative bool
+}
+
+var _ Expression = (*Predicate)(nil)
+var _ exprImpl = (*Predicate)(nil)
+
+func NewPredicate(name string, funcName string, negative bool) *Predicate {
+	rv := &Predicate{
+		name:     name,
+		funcName: funcName,
+		negative: negative,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (p *Predicate) exprName() string {
+	return p.name
+}
+
+func (p *Predicate) setExprName(n string) {
+	p.name = n
+}
+
+func (p *Predicate) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	f, ok := parseOpts.funcs.Predicates[p.funcName]
+	if !ok {
+		return matchFailed(fmt.Errorf("predicate %q is not registered", p.funcName))
+	}
+
+	// the function might examine any part of the text
+	cache.examine(cache.size(text) + 1)
+
+	pos := parseOpts.pos
+	matched, err := f(newMatchContext(text, parseOpts, cache))
+	if err != nil {
+		return matchFailed(fmt.Errorf("predicate %q: %w", p.funcName, err))
+	}
+	if matched == p.negative {
+		return noMatch()
+	}
+
+	return matchedNode(cache.newNode(p, text, pos, pos))
+}
+
+func (p *Predicate) asRule() string {
+	prefix := "&"
+	if p.negative {
+		prefix = "!"
+	}
+
+	return formatRuleRHSWithOptionalName(
+		p.exprName(),
+		fmt.Sprintf("%s{%s}", prefix, p.funcName),
+	)
+}
+
+// CustomMatcher is an expression which calls a Go function to match the text.
+type CustomMatcher struct {
+	expression
+
+	name     string
+	funcName string
+}
+
+var _ Expression = (*CustomMatcher)(nil)
+var _ exprImpl = (*CustomMatcher)(nil)
+
+func NewCustomMatcher(name string, funcName string) *CustomMatcher {
+	rv := &CustomMatcher{
+		name:     name,
+		funcName: funcName,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (m *CustomMatcher) exprName() string {
+	return m.name
+}
+
+func (m *CustomMatcher) setExprName(n string) {
+	m.name = n
+}
+
+func (m *CustomMatcher) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	f, ok := parseOpts.funcs.Matchers[m.funcName]
+	if !ok {
+		return matchFailed(fmt.Errorf("matcher %q is not registered", m.funcName))
+	}
+
+	// the function might examine any part of the text
+	size := cache.size(text)
+	cache.examine(size + 1)
+
+	pos := parseOpts.pos
+	length, matched, err := f(newMatchContext(text, parseOpts, cache))
+	if err != nil {
+		return matchFailed(fmt.Errorf("matcher %q: %w", m.funcName, err))
+	}
+	if !matched {
+		return noMatch()
+	}
+	if length < 0 || pos+length > size {
+		return matchFailed(fmt.Errorf("matcher %q: invalid match length %d at %d", m.funcName, length, pos))
+	}
+
+	return matchedNode(cache.newNode(m, text, pos, pos+length))
+}
+
+func (m *CustomMatcher) asRule() string {
+	return formatRuleRHSWithOptionalName(
+		m.exprName(),
+		fmt.Sprintf("@%s", m.funcName),
+	)
+}
+
+// checkFuncs checks that the Go functions called by the rules are registered.
+func checkFuncs(rules map[string]Expression, funcs Funcs) error {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	visited := make(map[Expression]struct{})
+	for _, name := range names {
+		if err := checkFuncsOf(rules[name], funcs, visited); err != nil {
+			return fmt.Errorf("rule %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func checkFuncsOf(expr Expression, funcs Funcs, visited map[Expression]struct{}) error {
+	if _, ok := visited[expr]; ok {
+		return nil
+	}
+	visited[expr] = struct{}{}
+
+	switch e := expr.(type) {
+	case *Predicate:
+		if _, ok := funcs.Predicates[e.funcName]; !ok {
+			return fmt.Errorf("predicate %q is not registered", e.funcName)
+		}
+	case *CustomMatcher:
+		if _, ok := funcs.Matchers[e.funcName]; !ok {
+			return fmt.Errorf("matcher %q is not registered", e.funcName)
+		}
+	}
+
+	for _, member := range membersOf(expr) {
+		if err := checkFuncsOf(member, funcs, visited); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Grammar_WithFuncs(t *testing.T) {
+	blob := NewSequence("blob", []Expression{
+		NewLiteral("<"),
+		NewCustomMatcher("", "identifier"),
+		NewLiteral(">"),
+	})
+	blobs := NewOneOrMore("blobs", NewSequence("", []Expression{NewPredicate("", "is_blob", false), blob}))
+	grammar := NewGrammar(map[string]Expression{"blobs": blobs, "blob": blob}, blobs)
+
+	_, err := grammar.WithFuncs(Funcs{
+		Predicates: map[string]PredicateFunc{
+			"is_blob": func(ctx *MatchContext) (bool, error) { return true, nil },
+		},
+	})
+	assert.EqualError(t, err, `rule "blob": matcher "identifier" is not registered`)
+
+	grammar, err = grammar.WithFuncs(Funcs{
+		Predicates: map[string]PredicateFunc{
+			"is_blob": func(ctx *MatchContext) (bool, error) { return true, nil },
+		},
+		Matchers: map[string]MatcherFunc{
+			"identifier": func(ctx *MatchContext) (int, bool, error) { return 1, true, nil },
+		},
+	})
+	assert.NoError(t, err)
+
+	tree, err := grammar.Parse("<a><b>")
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 2)
+}
@@ -1,11 +1,34 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"io/fs"
+	"sort"
+)
 
 // Grammar parses a text into a tree of nodes with defined grammar rules.
 type Grammar struct {
 	rules       map[string]Expression
 	defaultRule Expression
+	funcs       Funcs
+	recovery    map[string]Expression
+	skip        Expression
+	// definitions are the rules with lazy references, from which the rules are resolved.
+	// It's nil for the grammars created with resolved rules.
+	definitions map[string]Expression
+}
+
+// ParseWithFS sets the file system to load the grammars imported by @import directives.
+// It only applies to creating grammars from the grammar text.
+func ParseWithFS(fsys fs.FS) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.fsys = fsys
+	}
+}
+
+// ImportFS returns the file system set by ParseWithFS in the options, nil if it's not set.
+func ImportFS(opts ...ParseOption) fs.FS {
+	return createParseOpts(opts...).fsys
 }
 
 // NewGrammar creates a new grammar with the given rules and default rule.
@@ -24,8 +47,82 @@ func (g *Grammar) String() string {
 	)
 }
 
+// WithFuncs returns a copy of the grammar with the Go functions callable from the grammar rules.
+// All the functions called by the rules should be registered.
+func (g *Grammar) WithFuncs(funcs Funcs) (*Grammar, error) {
+	if err := checkFuncs(g.rules, funcs); err != nil {
+		return nil, err
+	}
+
+	rv := *g
+	rv.funcs = funcs
+	return &rv, nil
+}
+
+// WithRecovery returns a copy of the grammar which recovers from labeled failures.
+// The recovery maps failure labels to the names of the rules to match in place of the failed expressions.
+func (g *Grammar) WithRecovery(recovery map[string]string) (*Grammar, error) {
+	recoveryRules := make(map[string]Expression, len(recovery))
+	for label, ruleName := range recovery {
+		rule, ok := g.rules[ruleName]
+		if !ok {
+			return nil, fmt.Errorf("no such rule %q for recovering label %q", ruleName, label)
+		}
+		recoveryRules[label] = rule
+	}
+
+	rv := *g
+	rv.recovery = recoveryRules
+	return &rv, nil
+}
+
+// Default returns a copy of the grammar of which Parse starts from the rule.
+func (g *Grammar) Default(ruleName string) (*Grammar, error) {
+	rule, ok := g.rules[ruleName]
+	if !ok {
+		return nil, fmt.Errorf("no such rule %q to start from", ruleName)
+	}
+	if _, ok := rule.(*RuleTemplate); ok {
+		return nil, fmt.Errorf("parameterized rule %q can't be started from", ruleName)
+	}
+
+	rv := *g
+	rv.defaultRule = rule
+	return &rv, nil
+}
+
+// WithSkip returns a copy of the grammar which skips the rule between the terms of syntactic rules.
+// See ParseWithSkip for the details.
+func (g *Grammar) WithSkip(ruleName string) (*Grammar, error) {
+	rule, ok := g.rules[ruleName]
+	if !ok {
+		return nil, fmt.Errorf("no such rule %q to skip", ruleName)
+	}
+
+	return g.WithSkipExpression(rule), nil
+}
+
+// WithSkipExpression returns a copy of the grammar which skips the expression between the terms of syntactic rules.
+func (g *Grammar) WithSkipExpression(skip Expression) *Grammar {
+	rv := *g
+	rv.skip = skip
+	return &rv
+}
+
+// withGrammarParseOpts prepends the grammar level parse options and opts to parseOpts.
+func (g *Grammar) withGrammarParseOpts(parseOpts []ParseOption, opts ...ParseOption) []ParseOption {
+	grammarOpts := []ParseOption{ParseWithFuncs(g.funcs), ParseWithRecovery(g.recovery), ParseWithSkip(g.skip)}
+	opts = append(grammarOpts, opts...)
+	return append(opts, parseOpts...)
+}
+
 func (g *Grammar) Parse(text string, parseOpts ...ParseOption) (*Node, error) {
-	return ParseWithExpression(g.defaultRule, text, parseOpts...)
+	return ParseWithExpression(g.defaultRule, text, g.withGrammarParseOpts(parseOpts)...)
+}
+
+// ParseTokens parses the tokens with the default rule in token mode, see ParseTokensWithExpression.
+func (g *Grammar) ParseTokens(tokens []Token, parseOpts ...ParseOption) (*Node, error) {
+	return ParseTokensWithExpression(g.defaultRule, tokens, g.withGrammarParseOpts(parseOpts)...)
 }
 
 func (g *Grammar) ParseWithRule(ruleName string, text string, parseOpts ...ParseOption) (*Node, error) {
@@ -33,10 +130,36 @@ func (g *Grammar) ParseWithRule(ruleName string, text string, parseOpts ...Parse
 	if !ok {
 		return nil, fmt.Errorf("no such rule %q", ruleName)
 	}
-	return ParseWithExpression(rule, text, parseOpts...)
+	return ParseWithExpression(rule, text, g.withGrammarParseOpts(parseOpts)...)
+}
+
+// ParseEvents parses the text with the default rule, and reports the matched rules to the handler.
+func (g *Grammar) ParseEvents(text string, handler EventHandler, parseOpts ...ParseOption) error {
+	return ParseEventsWithExpression(g.defaultRule, text, handler, g.withGrammarParseOpts(parseOpts)...)
+}
+
+// Reparse parses the edited text incrementally with the rule of oldTree.
+// Memoized results outside the edited ranges reported by oldTree.Edit are reused.
+// The retained memo table is moved to the returned tree, so oldTree should not be reparsed again.
+func (g *Grammar) Reparse(oldTree *Node, text string, parseOpts ...ParseOption) (*Node, error) {
+	memo := oldTree.memo
+	oldTree.memo = nil
+
+	opts := g.withGrammarParseOpts(parseOpts, ParseWithIncremental(true), parseWithMemo(memo))
+	return ParseWithExpression(oldTree.Expression, text, opts...)
 }
 
 func (g *Grammar) GetRule(ruleName string) (Expression, bool) {
 	rule, ok := g.rules[ruleName]
 	return rule, ok
 }
+
+// RuleNames returns the sorted names of the rules in the grammar, including the built-in rules.
+func (g *Grammar) RuleNames() []string {
+	names := make([]string, 0, len(g.rules))
+	for name := range g.rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -22,10 +22,42 @@ func sliceStringAsRuneSlice(s string, from, to int) string {
 
 func sliceStringAsRuneSliceWithLength(s string, from, length int) string {
 	maxLength := utf8.RuneCountInString(s)
+	if from > maxLength {
+		from = maxLength
+	}
 	to := from + length
-	if to >= maxLength {
-		to = maxLength - 1
+	if to > maxLength {
+		// the slice is empty at the end of s
+		to = maxLength
 	}
 
 	return sliceStringAsRuneSlice(s, from, to)
 }
+
+// membersOf returns the member expressions of the expression, nil for the terminals.
+func membersOf(expr Expression) []Expression {
+	switch e := expr.(type) {
+	case *Sequence:
+		return e.members
+	case *OneOf:
+		return e.members
+	case *Lookahead:
+		return []Expression{e.member}
+	case *Quantifier:
+		return []Expression{e.member}
+	case *Throw:
+		return []Expression{e.member}
+	case *Precedence:
+		members := []Expression{e.operand}
+		for _, operator := range e.operators {
+			members = append(members, operator.Expression)
+		}
+		return members
+	case *RuleTemplate:
+		return []Expression{e.body}
+	case *LazyReference:
+		return e.args
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,142 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IndentationKind is the kind of an indentation expression.
+type IndentationKind int
+
+const (
+	// Indent matches a line indented deeper than the current level, and pushes a new level.
+	Indent IndentationKind = iota
+	// Dedent matches a line indented shallower than the current level, and pops the current level.
+	Dedent
+	// Samedent matches and consumes the indentation of a line at the current level.
+	Samedent
+)
+
+// Indentation matches the indentation at the start of a line against the indentation stack.
+//
+// The indentation stack is kept along with the parsing state, so changes to it are rolled back
+// on backtracking. Indent and Dedent are zero-length expressions; Samedent consumes the indentation.
+// Indentation expressions should be placed at the start of lines.
+type Indentation struct {
+	expression
+
+	name string
+	kind IndentationKind
+}
+
+var _ Expression = (*Indentation)(nil)
+var _ exprImpl = (*Indentation)(nil)
+
+func NewIndentation(name string, kind IndentationKind) *Indentation {
+	rv := &Indentation{
+		name: name,
+		kind: kind,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func NewIndent() *Indentation {
+	return NewIndentation("INDENT", Indent)
+}
+
+func NewDedent() *Indentation {
+	return NewIndentation("DEDENT", Dedent)
+}
+
+func NewSamedent() *Indentation {
+	return NewIndentation("SAMEDENT", Samedent)
+}
+
+func (i *Indentation) exprName() string {
+	return i.name
+}
+
+func (i *Indentation) setExprName(n string) {
+	i.name = n
+}
+
+// leadingIndentation returns the leading spaces and tabs at the rune position of the runes,
+// and reports if they are followed by the end of input.
+// It only scans up to the first rune which isn't a space or a tab.
+func leadingIndentation(runes []rune, pos int) (string, bool) {
+	end := pos
+	for end < len(runes) && (runes[end] == ' ' || runes[end] == '\t') {
+		end++
+	}
+
+	return string(runes[pos:end]), end == len(runes)
+}
+
+func (i *Indentation) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	indentation, atEOF := leadingIndentation(cache.runesOf(text), pos)
+	indentationLength := len([]rune(indentation))
+	cache.examine(pos + indentationLength + 1)
+	if atEOF {
+		// the end of input closes all the levels
+		indentation = ""
+	}
+
+	current := ""
+	if len(cache.indents) > 0 {
+		current = cache.indents[len(cache.indents)-1]
+	}
+	if !strings.HasPrefix(indentation, current) && !strings.HasPrefix(current, indentation) {
+		return matchFailed(newErrIndentation(text, pos, i, "inconsistent use of tabs and spaces in indentation"))
+	}
+
+	switch i.kind {
+	case Indent:
+		if len(indentation) <= len(current) {
+			return noMatch()
+		}
+		cache.saveState(parseOpts.state)
+		cache.indents = append(append([]string{}, cache.indents...), indentation)
+		cache.changeState()
+		return matchedNode(cache.newNode(i, text, pos, pos))
+	case Dedent:
+		if len(indentation) >= len(current) {
+			return noMatch()
+		}
+		cache.saveState(parseOpts.state)
+		cache.indents = cache.indents[:len(cache.indents)-1]
+		cache.changeState()
+		outer := ""
+		if len(cache.indents) > 0 {
+			outer = cache.indents[len(cache.indents)-1]
+		}
+		if len(outer) < len(indentation) {
+			return matchFailed(newErrIndentation(text, pos, i, "unindent does not match any outer indentation level"))
+		}
+		return matchedNode(cache.newNode(i, text, pos, pos))
+	default:
+		if atEOF || indentation != current {
+			return noMatch()
+		}
+		return matchedNode(cache.newNode(i, text, pos, pos+indentationLength))
+	}
+}
+
+func (i *Indentation) asRule() string {
+	var rhs string
+	switch i.kind {
+	case Indent:
+		rhs = "INDENT"
+	case Dedent:
+		rhs = "DEDENT"
+	default:
+		rhs = "SAMEDENT"
+	}
+
+	return formatRuleRHSWithOptionalName(
+		i.exprName(),
+		fmt.Sprintf("<%s>", rhs),
+	)
+}
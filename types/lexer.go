@@ -0,0 +1,133 @@
+package types
+
+import "fmt"
+
+// DefaultErrorTokenRule is the rule of the tokens of the unrecognized text, see Lexer.WithErrorRule.
+const DefaultErrorTokenRule = "error"
+
+// Lexer tokenizes a text with the terminal rules of a grammar, into a flat token stream.
+//
+// At each position, the rules are matched as lexical rules, so the skip expression doesn't apply.
+// By default the longest match wins, and the first of the rules with the same length.
+// The consecutive characters matched by none of the rules are reported as an error token.
+type Lexer struct {
+	rules      []Expression
+	ruleNames  []string
+	firstMatch bool
+	errorRule  string
+	parseOpts  []ParseOption
+}
+
+// Lexer creates a lexer which tokenizes texts with the rules, in the order of precedence.
+func (g *Grammar) Lexer(tokenRules ...string) (*Lexer, error) {
+	if len(tokenRules) == 0 {
+		return nil, fmt.Errorf("no token rules for the lexer")
+	}
+
+	rules := make([]Expression, len(tokenRules))
+	for idx, ruleName := range tokenRules {
+		rule, ok := g.rules[ruleName]
+		if !ok {
+			return nil, fmt.Errorf("no such rule %q to tokenize with", ruleName)
+		}
+		if _, ok := rule.(*RuleTemplate); ok {
+			return nil, fmt.Errorf("parameterized rule %q can't tokenize", ruleName)
+		}
+		rules[idx] = rule
+	}
+
+	return &Lexer{
+		rules:     rules,
+		ruleNames: tokenRules,
+		errorRule: DefaultErrorTokenRule,
+		parseOpts: g.withGrammarParseOpts(nil),
+	}, nil
+}
+
+// WithFirstMatch returns a copy of the lexer, which takes the first matching rule
+// instead of the longest match.
+func (l *Lexer) WithFirstMatch(firstMatch bool) *Lexer {
+	rv := *l
+	rv.firstMatch = firstMatch
+	return &rv
+}
+
+// WithErrorRule returns a copy of the lexer, which reports the unrecognized text
+// as the tokens of the rule.
+func (l *Lexer) WithErrorRule(errorRule string) *Lexer {
+	rv := *l
+	rv.errorRule = errorRule
+	return &rv
+}
+
+// Tokenize tokenizes the text. The whole text is covered by the tokens,
+// including the error tokens of the unrecognized text.
+// The errors of the rules, e.g. from the Go functions, are returned rather than taken as no match.
+func (l *Lexer) Tokenize(text string, parseOpts ...ParseOption) ([]Token, error) {
+	opts := createParseOpts(append(append([]ParseOption{}, l.parseOpts...), parseOpts...)...).withLexical()
+	cache := newNodeCache()
+	runes := cache.runesOf(text)
+
+	var tokens []Token
+	errorStart := -1
+	flushError := func(end int) {
+		if errorStart < 0 {
+			return
+		}
+		tokens = append(tokens, Token{
+			Rule:  l.errorRule,
+			Start: errorStart,
+			End:   end,
+			Text:  string(runes[errorStart:end]),
+		})
+		errorStart = -1
+	}
+
+	for pos := 0; pos < len(runes); {
+		// the tokens don't overlap, so the results before the position won't be used again
+		cache.dropBefore(pos)
+		ruleIdx, end, err := l.match(text, opts.withPos(pos), cache)
+		if err != nil {
+			return nil, err
+		}
+		if ruleIdx < 0 {
+			if errorStart < 0 {
+				errorStart = pos
+			}
+			pos++
+			continue
+		}
+
+		flushError(pos)
+		tokens = append(tokens, Token{
+			Rule:  l.ruleNames[ruleIdx],
+			Start: pos,
+			End:   end,
+			Text:  string(runes[pos:end]),
+		})
+		pos = end
+	}
+	flushError(len(runes))
+
+	return tokens, nil
+}
+
+// match matches the rules at the position, and returns the index of the matched rule and the end of the match.
+// Empty matches are taken as no match. It returns -1 if none of the rules matches.
+func (l *Lexer) match(text string, parseOpts *ParseOptions, cache *nodeCache) (int, int, error) {
+	matched, end := -1, parseOpts.pos
+	for idx, rule := range l.rules {
+		result := rule.matchWithCache(text, parseOpts, cache)
+		if result.isMatchFailed() {
+			return -1, end, fmt.Errorf("rule %q at %d: %w", l.ruleNames[idx], parseOpts.pos, result.Err)
+		}
+		if !result.isMatchedNode() || result.Node.End <= end {
+			continue
+		}
+		matched, end = idx, result.Node.End
+		if l.firstMatch {
+			break
+		}
+	}
+	return matched, end, nil
+}
@@ -0,0 +1,44 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Lexer_Tokenize(t *testing.T) {
+	word := NewRegex("word", regexp2.MustCompile(`^[a-z]+`, regexp2.RE2))
+	space := NewLiteralWithName("space", " ")
+	grammar := NewGrammar(map[string]Expression{"word": word, "space": space}, word)
+
+	lexer, err := grammar.Lexer("word", "space")
+	assert.NoError(t, err)
+	text := strings.Repeat("ab ", 1000)
+	tokens, err := lexer.Tokenize(text)
+	assert.NoError(t, err)
+	assert.Len(t, tokens, 2000)
+	assert.Equal(t, Token{Rule: "space", Start: 2999, End: 3000, Text: " "}, tokens[1999])
+}
+
+func Test_Lexer_TokenizeErrors(t *testing.T) {
+	failed := errors.New("failed")
+	matcher := NewCustomMatcher("matcher", "fail")
+	grammar, err := NewGrammar(map[string]Expression{"matcher": matcher}, matcher).WithFuncs(Funcs{
+		Matchers: map[string]MatcherFunc{
+			"fail": func(ctx *MatchContext) (int, bool, error) {
+				return 0, false, failed
+			},
+		},
+	})
+	assert.NoError(t, err)
+
+	lexer, err := grammar.Lexer("matcher")
+	assert.NoError(t, err)
+	tokens, err := lexer.Tokenize("a")
+	assert.ErrorIs(t, err, failed)
+	assert.ErrorContains(t, err, `rule "matcher" at 0`)
+	assert.Nil(t, tokens)
+}
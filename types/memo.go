@@ -0,0 +1,190 @@
+package types
+
+// memoKey identifies a memoized match attempt of an expression at a rune position.
+type memoKey struct {
+	expr         Expression
+	pos          int
+	lexical      bool
+	stateVersion uint64
+}
+
+// memoEntry is the result of a memoized match attempt.
+type memoEntry struct {
+	// node is the matched node, nil for a no match, nodeInProgress while matching.
+	node *Node
+	// extent is the exclusive rune position of the farthest character examined
+	// to produce this result. A value of len(text)+1 means the end of input was examined.
+	extent int
+	// stateVersion is the user state version after the match.
+	stateVersion uint64
+}
+
+var nodeInProgress = new(Node)
+
+// nodeCache is the packrat memo table of a parse.
+// It can be retained by the parsed tree and reused after edits for incremental reparsing.
+type nodeCache struct {
+	entries map[memoKey]*memoEntry
+	// examined tracks the farthest examined position of the expression being matched.
+	examined int
+
+	// stateVersion is the version of the current user state.
+	stateVersion uint64
+	// lastStateVersion is the last allocated user state version.
+	lastStateVersion uint64
+	// stateSnapshots are the state snapshots by version.
+	stateSnapshots map[uint64]stateSnapshot
+	// indents is the indentation stack of the current state.
+	indents []string
+	// matching are the match attempts in progress of the rules without memo, for detecting left recursions.
+	matching map[memoKey]struct{}
+
+	// runes are the runes of runesText, for looking up characters by rune position.
+	runes     []rune
+	runesText string
+	// lean is true if the nodes don't keep their text, see parseWithLean.
+	lean bool
+
+	// farthest is the farthest position of the failed attempts of the expected expressions, -1 if none.
+	farthest int
+	// expected are the expected expressions failed at the farthest position.
+	expected []Expression
+}
+
+func newNodeCache() *nodeCache {
+	return &nodeCache{
+		entries:        make(map[memoKey]*memoEntry),
+		stateSnapshots: make(map[uint64]stateSnapshot),
+		matching:       make(map[memoKey]struct{}),
+		farthest:       -1,
+	}
+}
+
+func (c *nodeCache) get(expr Expression, pos int, lexical bool) (*memoEntry, bool) {
+	entry, ok := c.entries[memoKey{expr: expr, pos: pos, lexical: lexical, stateVersion: c.stateVersion}]
+	return entry, ok
+}
+
+func (c *nodeCache) set(expr Expression, pos int, lexical bool, stateVersion uint64, entry *memoEntry) {
+	c.entries[memoKey{expr: expr, pos: pos, lexical: lexical, stateVersion: stateVersion}] = entry
+}
+
+func (c *nodeCache) unset(expr Expression, pos int, lexical bool, stateVersion uint64) {
+	delete(c.entries, memoKey{expr: expr, pos: pos, lexical: lexical, stateVersion: stateVersion})
+}
+
+// dropBefore drops the memoized results at the positions before pos, except the ones in progress.
+func (c *nodeCache) dropBefore(pos int) {
+	for key, entry := range c.entries {
+		if key.pos < pos && entry.node != nodeInProgress {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// runesOf returns the runes of the text, which are converted once for the text being parsed.
+func (c *nodeCache) runesOf(text string) []rune {
+	if c.runes == nil || c.runesText != text {
+		c.runes = []rune(text)
+		c.runesText = text
+	}
+	return c.runes
+}
+
+// runeAt returns the rune at the rune position of the text, and reports if the position is in the text.
+func (c *nodeCache) runeAt(text string, pos int) (rune, bool) {
+	runes := c.runesOf(text)
+	if pos < 0 || pos >= len(runes) {
+		return 0, false
+	}
+	return runes[pos], true
+}
+
+// size returns the rune count of the text.
+func (c *nodeCache) size(text string) int {
+	return len(c.runesOf(text))
+}
+
+// nodeText returns the text of the rune range [start, end), or empty in lean mode.
+func (c *nodeCache) nodeText(text string, start, end int) string {
+	if c.lean {
+		return ""
+	}
+	return string(c.runesOf(text)[start:end])
+}
+
+// examine records that the current match attempt has examined the text up to extent (exclusive).
+func (c *nodeCache) examine(extent int) {
+	if extent > c.examined {
+		c.examined = extent
+	}
+}
+
+// edit updates the memo table for a text edit which replaced the rune range [start, oldEnd)
+// with new content ending at newEnd. Entries which examined the edited range are dropped,
+// entries after the edited range are shifted.
+func (c *nodeCache) edit(start, oldEnd, newEnd int, shiftedNodes map[*Node]struct{}) {
+	delta := newEnd - oldEnd
+	entries := make(map[memoKey]*memoEntry, len(c.entries))
+	for key, entry := range c.entries {
+		switch {
+		case entry.node == nodeInProgress:
+			continue
+		case key.pos >= oldEnd:
+			key.pos += delta
+			entry.extent += delta
+			if entry.node != nil {
+				shiftNodePositions(entry.node, start, oldEnd, newEnd, shiftedNodes)
+			}
+		case entry.extent <= start:
+			// examined text is before the edit, keep as it is
+		default:
+			continue
+		}
+		entries[key] = entry
+	}
+
+	c.entries = entries
+}
+
+func shiftPosition(pos, start, oldEnd, newEnd int) int {
+	switch {
+	case pos >= oldEnd:
+		return pos + newEnd - oldEnd
+	case pos > newEnd:
+		return newEnd
+	default:
+		return pos
+	}
+}
+
+func shiftNodePositions(node *Node, start, oldEnd, newEnd int, shiftedNodes map[*Node]struct{}) {
+	if _, shifted := shiftedNodes[node]; shifted {
+		return
+	}
+	shiftedNodes[node] = struct{}{}
+	if node.End < start {
+		// nodes before the edit are not affected
+		return
+	}
+
+	node.Start = shiftPosition(node.Start, start, oldEnd, newEnd)
+	if node.End > start {
+		node.End = shiftPosition(node.End, start, oldEnd, newEnd)
+	}
+	if node.End < node.Start {
+		node.End = node.Start
+	}
+	for idx := range node.groups {
+		if group := &node.groups[idx]; group.Matched() && group.End >= start {
+			group.Start = shiftPosition(group.Start, start, oldEnd, newEnd)
+			group.End = shiftPosition(group.End, start, oldEnd, newEnd)
+		}
+	}
+	for _, child := range node.Children {
+		shiftNodePositions(child, start, oldEnd, newEnd, shiftedNodes)
+	}
+	for _, trivia := range node.Trivia {
+		shiftNodePositions(trivia, start, oldEnd, newEnd, shiftedNodes)
+	}
+}
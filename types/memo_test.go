@@ -0,0 +1,96 @@
+package types
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Grammar_ReparseAfterRegexMatch(t *testing.T) {
+	// the optional group of the regex examines the text after its match
+	item := NewSequence("item", []Expression{
+		NewRegex("", regexp2.MustCompile(`^[a-z]+( [a-z]*b)?`, regexp2.RE2)),
+		NewRegex("_", regexp2.MustCompile(`^ *`, regexp2.RE2)),
+	})
+	items := NewZeroOrMore("items", item)
+	grammar := NewGrammar(map[string]Expression{"items": items, "item": item}, items)
+
+	tree, err := grammar.Parse("axb yc", ParseWithIncremental(true))
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 2)
+
+	// replace "c" with "b", just past the match of the first item
+	tree.Edit(5, 6, 6)
+	newText := "axb yb"
+	newTree, err := grammar.Reparse(tree, newText)
+	assert.NoError(t, err)
+
+	freshTree, err := grammar.Parse(newText)
+	assert.NoError(t, err)
+	assert.Len(t, freshTree.Children, 1)
+	assert.Equal(t, len(freshTree.Children), len(newTree.Children))
+	for idx := range freshTree.Children {
+		assert.Equal(t, freshTree.Children[idx].Text, newTree.Children[idx].Text)
+	}
+}
+
+func Test_Grammar_CutDropsMemoizedResults(t *testing.T) {
+	let := NewLiteral("let")
+	name := NewRegex("name", regexp2.MustCompile(`^ [a-z]+`, regexp2.RE2))
+	statement := NewSequence("statement", []Expression{let, NewCut(), name, NewLiteral(";")})
+	statements := NewZeroOrMore("statements", statement)
+	grammar := NewGrammar(map[string]Expression{"statements": statements, "statement": statement}, statements)
+
+	cache := newNodeCache()
+	tree, err := grammar.Parse("let a;let b;", parseWithMemo(cache))
+	assert.NoError(t, err)
+	assert.Len(t, tree.Children, 2)
+	// the results before the cut of the last statement are dropped, the memo table is keyed by the embedded expressions
+	for _, pos := range []int{0, 6} {
+		_, ok := cache.get(&let.expression, pos, false)
+		assert.False(t, ok, "at %d", pos)
+	}
+
+	// the results are kept for reparsing in incremental mode
+	cache = newNodeCache()
+	_, err = grammar.Parse("let a;let b;", parseWithMemo(cache), ParseWithIncremental(true))
+	assert.NoError(t, err)
+	_, ok := cache.get(&let.expression, 0, false)
+	assert.True(t, ok)
+}
+
+func Test_Grammar_NoMemoLeftRecursion(t *testing.T) {
+	// sum = sum "+" "1" / "1"
+	sum := NewOneOf("sum", nil)
+	sum.SetMembers([]Expression{
+		NewSequence("", []Expression{sum, NewLiteral("+"), NewLiteral("1")}),
+		NewLiteral("1"),
+	})
+	sum.SetAnnotations(AnnotationNoMemo)
+	grammar := NewGrammar(map[string]Expression{"sum": sum}, sum)
+
+	_, err := grammar.Parse("1+1")
+	assert.IsType(t, &ErrLeftRecursion{}, err)
+}
+
+func Test_Grammar_CutInsideLookahead(t *testing.T) {
+	// statement = !("a" ~> "b") ~"[a-z]+"
+	guarded := NewSequence("", []Expression{NewLiteral("a"), NewCut(), NewLiteral("b")})
+	word := NewRegex("word", regexp2.MustCompile(`^[a-z]+`, regexp2.RE2))
+	statement := NewSequence("statement", []Expression{NewNot(guarded), word})
+
+	// the cut failure is a no match of the lookahead
+	_, err := ParseWithExpression(statement, "ac")
+	assert.NoError(t, err)
+	_, err = ParseWithExpression(statement, "ab")
+	var cutFailed *ErrCutFailed
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &cutFailed))
+
+	positive := NewSequence("statement", []Expression{NewLookahead("", guarded, false), word})
+	_, err = ParseWithExpression(positive, "ac")
+	assert.Error(t, err)
+	assert.False(t, errors.As(err, &cutFailed))
+}
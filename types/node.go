@@ -2,6 +2,7 @@ package types
 
 import (
 	"fmt"
+	"strconv"
 )
 
 // Node represents a node in the parse tree.
@@ -18,6 +19,17 @@ type Node struct {
 	Children []*Node
 	// Match is the string that matched this node from the regex expression.
 	Match string
+	// labels are the labels of the children, nil if none of the children is labeled.
+	labels []string
+	// Trivia are the nodes skipped by the skip expression within the node, which aren't children.
+	Trivia []*Node
+	// groups are the capture groups of the regex match, nil if the node isn't matched by a regex.
+	groups []Group
+	// recovered is true if the node of a labeled failure is matched by the recovery expression.
+	recovered bool
+
+	// memo is the memo table retained by the root node in incremental mode.
+	memo *nodeCache
 }
 
 func (n *Node) String() string {
@@ -27,7 +39,88 @@ func (n *Node) String() string {
 	)
 }
 
-func newNode(
+// ChildLabel returns the label of the idx-th child, empty if the child is unlabeled.
+func (n *Node) ChildLabel(idx int) string {
+	if idx < 0 || idx >= len(n.labels) || idx >= len(n.Children) {
+		return ""
+	}
+	return n.labels[idx]
+}
+
+// Child returns the first child labeled with the label, nil if there's no such child.
+func (n *Node) Child(label string) *Node {
+	for idx, l := range n.labels {
+		if l == label && idx < len(n.Children) {
+			return n.Children[idx]
+		}
+	}
+	return nil
+}
+
+// ChildrenNamed returns the children labeled with the labels, in the order of the labels.
+// The missing children are nil.
+func (n *Node) ChildrenNamed(labels ...string) []*Node {
+	children := make([]*Node, len(labels))
+	for idx, label := range labels {
+		children[idx] = n.Child(label)
+	}
+	return children
+}
+
+// Group is a capture group of a regex match.
+type Group struct {
+	// Name is the name of the group, or its number for unnamed groups.
+	Name string
+	// Text is the text captured by the group.
+	Text string
+	// Start is the rune start index of the capture in the parsed text, -1 if the group didn't participate in the match.
+	Start int
+	// End is the rune end index of the capture in the parsed text, -1 if the group didn't participate in the match.
+	End int
+}
+
+// Matched reports if the group participated in the match.
+func (g Group) Matched() bool {
+	return g.Start >= 0
+}
+
+// Groups returns the capture groups of the regex match by their numbers, excluding the whole match.
+// The groups of the grammar regexes are numbered from left to right as in Python, named or not.
+// The groups of a regex created with NewRegex without group names follow the regexp2 numbering,
+// where the named groups come after the unnamed ones.
+func (n *Node) Groups() []Group {
+	return n.groups
+}
+
+// Group returns the capture group with the name or number, nil if there's no such group
+// or the group didn't participate in the match.
+func (n *Node) Group(name string) *Group {
+	for idx := range n.groups {
+		if n.groups[idx].Name == name || strconv.Itoa(idx+1) == name {
+			if !n.groups[idx].Matched() {
+				return nil
+			}
+			return &n.groups[idx]
+		}
+	}
+	return nil
+}
+
+// Edit updates the tree for a text edit, which replaced the rune range [start, oldEnd)
+// with new content ending at newEnd. Nodes after the edit are shifted, and memoized results
+// which examined the edited range are invalidated.
+//
+// Edit should be called on the root node before passing it to Grammar.Reparse.
+func (n *Node) Edit(start, oldEnd, newEnd int) {
+	shiftedNodes := make(map[*Node]struct{})
+	if n.memo != nil {
+		n.memo.edit(start, oldEnd, newEnd, shiftedNodes)
+	}
+	shiftNodePositions(n, start, oldEnd, newEnd, shiftedNodes)
+}
+
+// newNode creates a node of the rune range of the text, of which text is kept unless in lean mode.
+func (c *nodeCache) newNode(
 	expression Expression,
 	fullText string,
 	start int,
@@ -35,33 +128,46 @@ func newNode(
 ) *Node {
 	return &Node{
 		Expression: expression,
-		Text:       sliceStringAsRuneSlice(fullText, start, end),
+		Text:       c.nodeText(fullText, start, end),
 		Start:      start,
 		End:        end,
 		Children:   make([]*Node, 0),
 	}
 }
 
-func newNodeWithChildren(
+func (c *nodeCache) newNodeWithChildren(
+	expression Expression,
+	fullText string,
+	start int,
+	end int,
+	children []*Node,
+) *Node {
+	return c.newNodeWithLabeledChildren(expression, fullText, start, end, children, nil)
+}
+
+func (c *nodeCache) newNodeWithLabeledChildren(
 	expression Expression,
 	fullText string,
 	start int,
 	end int,
 	children []*Node,
+	labels []string,
 ) *Node {
-	node := newNode(expression, fullText, start, end)
-	node.Children = children
+	node := c.newNode(expression, fullText, start, end)
+	node.Children, node.labels, node.Trivia = spliceInlineChildren(children, labels)
 	return node
 }
 
-func newRegexNode(
+func (c *nodeCache) newRegexNode(
 	expression Expression,
 	fullText string,
 	start int,
 	end int,
 	match string,
+	groups []Group,
 ) *Node {
-	node := newNode(expression, fullText, start, end)
+	node := c.newNode(expression, fullText, start, end)
 	node.Match = match
+	node.groups = groups
 	return node
 }
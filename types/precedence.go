@@ -0,0 +1,410 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// OperatorKind is the kind of an operator in a precedence expression.
+type OperatorKind int
+
+const (
+	// Infix operators are placed between two operands.
+	Infix OperatorKind = iota
+	// Prefix operators are placed before the operand.
+	Prefix
+	// Postfix operators are placed after the operand.
+	Postfix
+)
+
+func (k OperatorKind) String() string {
+	switch k {
+	case Prefix:
+		return "prefix"
+	case Postfix:
+		return "postfix"
+	default:
+		return "infix"
+	}
+}
+
+// Associativity is the associativity of an infix operator.
+type Associativity int
+
+const (
+	// LeftAssociative operators group from the left: a - b - c is (a - b) - c.
+	LeftAssociative Associativity = iota
+	// RightAssociative operators group from the right: a ^ b ^ c is a ^ (b ^ c).
+	RightAssociative
+)
+
+// Operator describes an operator of a precedence expression.
+type Operator struct {
+	// Expression matches the operator.
+	Expression Expression
+	// Kind is the kind of the operator.
+	Kind OperatorKind
+	// Associativity is the associativity of an infix operator.
+	Associativity Associativity
+	// Precedence is the binding power of the operator, higher binds tighter.
+	Precedence int
+}
+
+// OperationExprName returns the expression name of the operation nodes of a precedence rule.
+// The operations of a precedence expression inside a rule are named after the rule, see NameInlineOperations.
+func OperationExprName(ruleName string, kind OperatorKind) string {
+	return ruleName + ":" + kind.String()
+}
+
+// Operation labels the nodes of operator applications in the tree of a precedence expression.
+//
+// An infix operation node has the children of the left operand, the operator and the right operand.
+// A prefix operation node has the children of the operator and the operand, and a postfix operation
+// node has the children of the operand and the operator.
+type Operation struct {
+	expression
+
+	name string
+	kind OperatorKind
+}
+
+var _ Expression = (*Operation)(nil)
+var _ exprImpl = (*Operation)(nil)
+
+func newOperation(name string, kind OperatorKind) *Operation {
+	rv := &Operation{
+		name: name,
+		kind: kind,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+// Kind returns the operator kind of the operation.
+func (o *Operation) Kind() OperatorKind {
+	return o.kind
+}
+
+func (o *Operation) exprName() string {
+	return o.name
+}
+
+func (o *Operation) setExprName(n string) {
+	o.name = n
+}
+
+func (o *Operation) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	return matchFailed(fmt.Errorf("operation %q can't be matched directly", o.name))
+}
+
+func (o *Operation) asRule() string {
+	return formatRuleRHSWithOptionalName(
+		o.exprName(),
+		fmt.Sprintf("<%s operation>", o.kind),
+	)
+}
+
+// Precedence matches operands combined with operators by precedence climbing.
+//
+// The matched node has a single child, which is either the operand node or an operation node.
+// Operations are nested by the precedence and associativity of the operators.
+type Precedence struct {
+	expression
+
+	name       string
+	operand    Expression
+	operators  []Operator
+	operations map[OperatorKind]*Operation
+}
+
+var _ Expression = (*Precedence)(nil)
+var _ exprImpl = (*Precedence)(nil)
+var _ withResolveRefs = (*Precedence)(nil)
+var _ withUnresolveRefs = (*Precedence)(nil)
+
+func NewPrecedence(name string, operand Expression, operators []Operator) *Precedence {
+	rv := &Precedence{
+		name:      name,
+		operand:   operand,
+		operators: operators,
+		operations: map[OperatorKind]*Operation{
+			Infix:   newOperation(OperationExprName(name, Infix), Infix),
+			Prefix:  newOperation(OperationExprName(name, Prefix), Prefix),
+			Postfix: newOperation(OperationExprName(name, Postfix), Postfix),
+		},
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (p *Precedence) exprName() string {
+	return p.name
+}
+
+func (p *Precedence) setExprName(n string) {
+	p.name = n
+	p.setOperationsName(n)
+}
+
+func (p *Precedence) setOperationsName(n string) {
+	for kind, operation := range p.operations {
+		operation.setExprName(OperationExprName(n, kind))
+	}
+}
+
+// NameInlineOperations names the operations of the unnamed precedence expression inside the rule
+// after the rule, as the operations of an unnamed precedence expression would be named ":infix" etc.
+// It reports an error if the rule has more than one, as their operations couldn't be told apart.
+func NameInlineOperations(rule Expression) error {
+	var inline []*Precedence
+	var walk func(expr Expression)
+	walk = func(expr Expression) {
+		for _, member := range membersOf(expr) {
+			if member.ExprName() != "" {
+				continue
+			}
+			if p, ok := member.(*Precedence); ok {
+				inline = append(inline, p)
+			}
+			walk(member)
+		}
+	}
+	walk(rule)
+
+	if len(inline) > 1 {
+		return fmt.Errorf(
+			"rule %q has %d inline precedence expressions, of which operations can't be told apart",
+			rule.ExprName(), len(inline),
+		)
+	}
+	for _, p := range inline {
+		p.setOperationsName(rule.ExprName())
+	}
+	return nil
+}
+
+// matchOperator matches an operator of the given kind at the position.
+// The longest operator wins, e.g. "**" over "*", and the first in the table of the same length.
+func (p *Precedence) matchOperator(
+	text string,
+	parseOpts *ParseOptions,
+	cache *nodeCache,
+	kind OperatorKind,
+	minPrecedence int,
+) (*Operator, *matchResult) {
+	var matched *Operator
+	var matchedResult *matchResult
+	stateVersion := cache.stateVersion
+	matchedStateVersion := stateVersion
+	for idx := range p.operators {
+		operator := &p.operators[idx]
+		if operator.Kind != kind || operator.Precedence < minPrecedence {
+			continue
+		}
+
+		// each operator is tried from the same state
+		cache.switchState(parseOpts.state, stateVersion)
+		matchResult := operator.Expression.matchWithCache(text, parseOpts, cache)
+		if matchResult.isMatchFailed() {
+			return nil, matchResult
+		}
+		if matchResult.isNoMatch() {
+			continue
+		}
+		if matchResult.Node.End == matchResult.Node.Start {
+			// operators must consume text, or the operations would never end
+			continue
+		}
+		if matched == nil || matchResult.Node.End > matchedResult.Node.End {
+			matched, matchedResult, matchedStateVersion = operator, matchResult, cache.stateVersion
+		}
+	}
+
+	cache.switchState(parseOpts.state, matchedStateVersion)
+	if matched == nil {
+		return nil, noMatch()
+	}
+	return matched, matchedResult
+}
+
+// skipTrivia skips the trivia before the operators and the operands after them in syntactic rules.
+func (p *Precedence) skipTrivia(text string, parseOpts *ParseOptions, cache *nodeCache) ([]*Node, int, *matchResult) {
+	if !parseOpts.shouldSkip() {
+		return nil, parseOpts.pos, nil
+	}
+	return skipTrivia(text, parseOpts, cache)
+}
+
+// climb matches an operand with the operators binding at least minPrecedence.
+func (p *Precedence) climb(text string, parseOpts *ParseOptions, cache *nodeCache, minPrecedence int) *matchResult {
+	pos := parseOpts.pos
+
+	var lhs *Node
+	stateVersion := cache.stateVersion
+	// prefix operators are allowed wherever an operand is expected
+	prefix, matchResult := p.matchOperator(text, parseOpts, cache, Prefix, 0)
+	if matchResult.isMatchFailed() {
+		return matchResult
+	}
+	if matchResult.isMatchedNode() {
+		operatorNode := matchResult.Node
+		trivia, operandPos, failed := p.skipTrivia(text, parseOpts.withPos(operatorNode.End), cache)
+		if failed != nil {
+			return failed
+		}
+		operandResult := p.climb(text, parseOpts.withPos(operandPos), cache, prefix.Precedence)
+		if operandResult.isMatchFailed() {
+			return operandResult
+		}
+		if operandResult.isMatchedNode() {
+			lhs = cache.newNodeWithChildren(
+				p.operations[Prefix], text, pos, operandResult.Node.End,
+				[]*Node{operatorNode, operandResult.Node},
+			)
+			lhs.Trivia = append(lhs.Trivia, trivia...)
+		} else {
+			// try matching the operator text as part of the operand instead
+			cache.switchState(parseOpts.state, stateVersion)
+		}
+	}
+	if lhs == nil {
+		operandResult := p.operand.matchWithCache(text, parseOpts, cache)
+		if !operandResult.isMatchedNode() {
+			return operandResult
+		}
+		lhs = operandResult.Node
+	}
+
+	for {
+		stateVersion = cache.stateVersion
+		trivia, operatorPos, failed := p.skipTrivia(text, parseOpts.withPos(lhs.End), cache)
+		if failed != nil {
+			return failed
+		}
+		curOpts := parseOpts.withPos(operatorPos)
+
+		_, matchResult := p.matchOperator(text, curOpts, cache, Postfix, minPrecedence)
+		if matchResult.isMatchFailed() {
+			return matchResult
+		}
+		if matchResult.isMatchedNode() {
+			operatorNode := matchResult.Node
+			lhs = cache.newNodeWithChildren(
+				p.operations[Postfix], text, pos, operatorNode.End,
+				[]*Node{lhs, operatorNode},
+			)
+			lhs.Trivia = append(lhs.Trivia, trivia...)
+			continue
+		}
+
+		infix, matchResult := p.matchOperator(text, curOpts, cache, Infix, minPrecedence)
+		if matchResult.isMatchFailed() {
+			return matchResult
+		}
+		if matchResult.isNoMatch() {
+			break
+		}
+		operatorNode := matchResult.Node
+		nextMinPrecedence := infix.Precedence + 1
+		if infix.Associativity == RightAssociative {
+			nextMinPrecedence = infix.Precedence
+		}
+		rhsTrivia, operandPos, failed := p.skipTrivia(text, parseOpts.withPos(operatorNode.End), cache)
+		if failed != nil {
+			return failed
+		}
+		rhsResult := p.climb(text, parseOpts.withPos(operandPos), cache, nextMinPrecedence)
+		if rhsResult.isMatchFailed() {
+			return rhsResult
+		}
+		if rhsResult.isNoMatch() {
+			// the operator isn't followed by an operand, leave it unconsumed
+			cache.switchState(parseOpts.state, stateVersion)
+			break
+		}
+		lhs = cache.newNodeWithChildren(
+			p.operations[Infix], text, pos, rhsResult.Node.End,
+			[]*Node{lhs, operatorNode, rhsResult.Node},
+		)
+		lhs.Trivia = append(lhs.Trivia, trivia...)
+		lhs.Trivia = append(lhs.Trivia, rhsTrivia...)
+	}
+
+	return matchedNode(lhs)
+}
+
+func (p *Precedence) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	matchResult := p.climb(text, parseOpts, cache, 0)
+	if !matchResult.isMatchedNode() {
+		return matchResult
+	}
+
+	child := matchResult.Node
+	node := cache.newNodeWithChildren(p, text, parseOpts.pos, child.End, []*Node{child})
+	return matchedNode(node)
+}
+
+func (p *Precedence) ResolveRefs(refs map[string]Expression) (Expression, error) {
+	newOperand, err := ResolveRefsFor(p.operand, refs)
+	if err != nil {
+		return nil, err
+	}
+	p.operand = newOperand
+
+	for idx := range p.operators {
+		newOperator, err := ResolveRefsFor(p.operators[idx].Expression, refs)
+		if err != nil {
+			return nil, err
+		}
+		p.operators[idx].Expression = newOperator
+	}
+
+	return p, nil
+}
+
+func (p *Precedence) unresolveRefs(u *refsUnresolver) Expression {
+	rv := *p
+	rv.expression.impl = &rv
+	u.copies[p] = &rv
+	// the operations are renamed with the copy
+	rv.operations = make(map[OperatorKind]*Operation, len(p.operations))
+	for kind, operation := range p.operations {
+		rv.operations[kind] = newOperation(operation.name, kind)
+	}
+	rv.operand = u.unresolve(p.operand)
+	rv.operators = make([]Operator, len(p.operators))
+	for idx, operator := range p.operators {
+		operator.Expression = u.unresolve(operator.Expression)
+		rv.operators[idx] = operator
+	}
+	return &rv
+}
+
+func (p *Precedence) asRule() string {
+	var sb strings.Builder
+	lastPrecedence := -1
+	for idx, operator := range p.operators {
+		if operator.Precedence != lastPrecedence {
+			if idx > 0 {
+				sb.WriteString("; ")
+			}
+			kind := operator.Kind.String()
+			if operator.Kind == Infix {
+				kind = "left"
+				if operator.Associativity == RightAssociative {
+					kind = "right"
+				}
+			}
+			sb.WriteString(kind + ":")
+			lastPrecedence = operator.Precedence
+		}
+		sb.WriteString(" " + joinExpressionAsRule(operator.Expression))
+	}
+
+	return formatRuleRHSWithOptionalName(
+		p.exprName(),
+		fmt.Sprintf("%%precedence(%s) {%s}", joinExpressionAsRule(p.operand), sb.String()),
+	)
+}
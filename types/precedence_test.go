@@ -0,0 +1,35 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func describeOperations(node *Node) string {
+	switch node.Expression.(type) {
+	case *Precedence:
+		return describeOperations(node.Children[0])
+	case *Operation:
+		return fmt.Sprintf(
+			"(%s %s %s)",
+			describeOperations(node.Children[0]), node.Children[1].Text, describeOperations(node.Children[2]),
+		)
+	default:
+		return node.Text
+	}
+}
+
+func Test_Precedence_LongestOperator(t *testing.T) {
+	number := NewRegex("number", regexp2.MustCompile(`^[0-9]+`, regexp2.RE2))
+	expression := NewPrecedence("expression", number, []Operator{
+		{Expression: NewLiteral("*"), Kind: Infix, Associativity: LeftAssociative, Precedence: 1},
+		{Expression: NewLiteral("**"), Kind: Infix, Associativity: RightAssociative, Precedence: 2},
+	})
+
+	node, err := ParseWithExpression(expression, "2**3**2*4")
+	assert.NoError(t, err)
+	assert.Equal(t, "((2 ** (3 ** 2)) * 4)", describeOperations(node))
+}
@@ -0,0 +1,98 @@
+package types
+
+import (
+	"unicode"
+	"unicode/utf8"
+)
+
+// ParseWithSkip sets the skip expression on parsing.
+//
+// The skip expression is matched repeatedly between the members of sequences, the repetitions
+// of quantifiers and the operators and operands of precedence expressions in syntactic rules,
+// so the grammar doesn't need to spell out whitespaces and comments.
+// Lexical rules, of which names start with an uppercase letter or which are annotated with @token,
+// match the text exactly, and so do the rules referenced from lexical rules.
+//
+// The skipped nodes are kept as the trivia of the enclosing nodes.
+func ParseWithSkip(skip Expression) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.skip = skip
+	}
+}
+
+// isLexicalRule reports if the expression is a lexical rule, which isn't affected by the skip expression.
+// Lexical rules are the rules of which names start with an uppercase letter, and the @token rules.
+func isLexicalRule(expr Expression) bool {
+	if expr.Annotations().Has(AnnotationToken) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(expr.ExprName())
+	return unicode.IsUpper(r)
+}
+
+func (opts *ParseOptions) withLexical() *ParseOptions {
+	newOpts := *opts
+	newOpts.lexical = true
+	return &newOpts
+}
+
+// shouldSkip reports if the skip expression applies to the current match attempt.
+func (opts *ParseOptions) shouldSkip() bool {
+	return opts.skip != nil && !opts.lexical
+}
+
+// skipTrivia matches the skip expression repeatedly at the position.
+// It returns the skipped nodes and the position after them.
+func skipTrivia(text string, parseOpts *ParseOptions, cache *nodeCache) ([]*Node, int, *matchResult) {
+	curPos := parseOpts.pos
+	// the trivia isn't reported in event mode, nor expected in the parse failures
+	skipOpts := parseOpts.withLexical().withCommitted(false).withSilent()
+
+	var trivia []*Node
+	for {
+		matchResult := parseOpts.skip.matchWithCache(text, skipOpts.withPos(curPos), cache)
+		if matchResult.isMatchFailed() {
+			return nil, parseOpts.pos, matchResult
+		}
+		if matchResult.isNoMatch() || matchResult.Node.End == curPos {
+			return trivia, curPos, nil
+		}
+		trivia = append(trivia, matchResult.Node)
+		curPos = matchResult.Node.End
+	}
+}
+
+// skipLeadingAndTrailingTrivia wraps the parsed root node with the trivia around it,
+// so the returned node spans the whole text.
+func skipLeadingAndTrailingTrivia(
+	expr Expression,
+	text string,
+	parseOpts *ParseOptions,
+	cache *nodeCache,
+) (*Node, error) {
+	leading, pos, failed := skipTrivia(text, parseOpts, cache)
+	if failed != nil {
+		return nil, failed.Err
+	}
+
+	node, err := matchWithMemo(expr, text, parseOpts.withPos(pos), cache)
+	if err != nil {
+		return nil, err
+	}
+
+	trailing, end, failed := skipTrivia(text, parseOpts.withPos(node.End), cache)
+	if failed != nil {
+		return nil, failed.Err
+	}
+	if len(leading) == 0 && len(trailing) == 0 {
+		return node, nil
+	}
+
+	// the matched node might be memoized, update a copy of it
+	root := cache.newNodeWithLabeledChildren(expr, text, parseOpts.pos, end, node.Children, node.labels)
+	root.Match = node.Match
+	root.groups = node.groups
+	root.Trivia = append(leading, node.Trivia...)
+	root.Trivia = append(root.Trivia, trailing...)
+	return root, nil
+}
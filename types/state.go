@@ -0,0 +1,74 @@
+package types
+
+// State is a mutable user state threaded through the parsing.
+//
+// The state can be read and changed by the Go functions called from grammars.
+// Changes are rolled back automatically when the parser backtracks.
+type State interface {
+	// Snapshot returns a snapshot of the current state.
+	// The snapshot must be an independent copy, which isn't affected by the later changes to the state,
+	// e.g. a copy of a map instead of the map itself.
+	Snapshot() any
+	// Restore restores the state to the given snapshot.
+	// The same snapshot can be restored many times, so the state must not share
+	// the mutable parts of the snapshot after restoring it.
+	Restore(snapshot any)
+}
+
+// ParseWithState sets the user state on parsing.
+// When reparsing incrementally, the state should be reset to the initial state of the previous parse.
+func ParseWithState(state State) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.state = state
+	}
+}
+
+// StateChanged marks the user state as changed by the function.
+// It must be called after changing the state, so the change can be rolled back on backtracking,
+// and memoized results are keyed by the new state.
+func (ctx *MatchContext) StateChanged() {
+	if ctx.cache == nil {
+		return
+	}
+
+	ctx.cache.changeState()
+}
+
+// stateSnapshot is a snapshot of the states threaded through the parsing.
+type stateSnapshot struct {
+	user    any
+	indents []string
+}
+
+// changeState allocates a new state version for the current state.
+func (c *nodeCache) changeState() {
+	c.lastStateVersion++
+	c.stateVersion = c.lastStateVersion
+}
+
+// saveState takes a snapshot of the current state version if it hasn't been taken yet.
+func (c *nodeCache) saveState(state State) {
+	if _, ok := c.stateSnapshots[c.stateVersion]; ok {
+		return
+	}
+
+	snapshot := stateSnapshot{indents: c.indents}
+	if state != nil {
+		snapshot.user = state.Snapshot()
+	}
+	c.stateSnapshots[c.stateVersion] = snapshot
+}
+
+// switchState restores the state to the given state version.
+func (c *nodeCache) switchState(state State, version uint64) {
+	if c.stateVersion == version {
+		return
+	}
+
+	snapshot := c.stateSnapshots[version]
+	if state != nil {
+		state.Restore(snapshot.user)
+	}
+	c.indents = snapshot.indents
+	c.stateVersion = version
+}
@@ -0,0 +1,190 @@
+package types
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// SuggestionKind is the kind of a completion suggestion.
+type SuggestionKind int
+
+const (
+	// SuggestionLiteral suggests a literal text to complete the partial word with.
+	SuggestionLiteral SuggestionKind = iota
+	// SuggestionCategory hints the category of the text expected, like a regex or a named rule.
+	SuggestionCategory
+)
+
+func (k SuggestionKind) String() string {
+	switch k {
+	case SuggestionLiteral:
+		return "literal"
+	case SuggestionCategory:
+		return "category"
+	default:
+		return "unknown"
+	}
+}
+
+// Suggestion is what can come next at the cursor position, see Grammar.Suggest.
+type Suggestion struct {
+	Kind SuggestionKind
+	// Text is the literal to complete with, or the name of the category.
+	// A category is named by the display name of the expression, or its rule name, or the expression itself.
+	Text string
+	// Partial is the partial word before the cursor being completed.
+	Partial string
+	// Start is the rune position of the partial word, from which the completion replaces the text.
+	Start int
+	// Expression is the suggested expression.
+	Expression Expression
+}
+
+// Suggest returns what can come next at the rune position cursor of the text.
+//
+// The text before the partial word at the cursor, the letters, digits and underscores before it,
+// is parsed with the default rule, and the expressions expected at the end of it are collected,
+// as in ErrParseFailed.Expected, so the attempts inside lookaheads aren't suggested.
+// Literals starting with the partial word are suggested as completions, while regexes,
+// character classes and the rules with display names which match the start of the partial word
+// are suggested as category hints, named by their nearest enclosing rule if they're unnamed.
+// Literals come first, and suggestions of the same kind are sorted by the text.
+func (g *Grammar) Suggest(text string, cursor int, parseOpts ...ParseOption) []Suggestion {
+	runes := []rune(text)
+	if cursor < 0 {
+		cursor = 0
+	}
+	if cursor > len(runes) {
+		cursor = len(runes)
+	}
+	start := cursor
+	for start > 0 && isWordRune(runes[start-1]) {
+		start--
+	}
+	partial := string(runes[start:cursor])
+	before := string(runes[:start])
+
+	cache := newNodeCache()
+	opts := g.withGrammarParseOpts(parseOpts, parseWithMemo(cache))
+	// the parse is expected to fail, the attempts at the end of the text are what we need:
+	// they're the expected expressions of the parse, which leave out the attempts inside lookaheads
+	// and the skip expression
+	_, _ = ParseWithExpression(g.defaultRule, before, opts...)
+	if cache.farthest != start {
+		return nil
+	}
+
+	seen := make(map[Suggestion]struct{})
+	var suggestions []Suggestion
+	add := func(kind SuggestionKind, text string, expr Expression) {
+		key := Suggestion{Kind: kind, Text: text}
+		if _, ok := seen[key]; ok {
+			return
+		}
+		seen[key] = struct{}{}
+		suggestions = append(suggestions, Suggestion{
+			Kind:       kind,
+			Text:       text,
+			Partial:    partial,
+			Start:      start,
+			Expression: expr,
+		})
+	}
+
+	partialOpts := createParseOpts(opts...).withLexical()
+	var enclosing map[Expression]string
+	for _, expr := range cache.expected {
+		switch impl := expr.(type) {
+		case *Literal:
+			if impl.literal != "" && hasLiteralPrefix(impl, partial) {
+				add(SuggestionLiteral, impl.literal, expr)
+			}
+		case *Regex, *CharClass:
+			if matchesPartial(expr, partial, partialOpts) {
+				if enclosing == nil {
+					enclosing = enclosingRules(g.rules)
+				}
+				add(SuggestionCategory, describeCategory(expr, enclosing), expr)
+			}
+		default:
+			if expr.DisplayName() != "" && matchesPartial(expr, partial, partialOpts) {
+				add(SuggestionCategory, expr.DisplayName(), expr)
+			}
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Kind != suggestions[j].Kind {
+			return suggestions[i].Kind < suggestions[j].Kind
+		}
+		return suggestions[i].Text < suggestions[j].Text
+	})
+	return suggestions
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// hasLiteralPrefix reports if the literal starts with the partial word.
+func hasLiteralPrefix(l *Literal, partial string) bool {
+	if strings.HasPrefix(l.literal, partial) {
+		return true
+	}
+	if !l.caseInsensitive {
+		return false
+	}
+	literal := []rune(l.literal)
+	prefix := []rune(partial)
+	return len(prefix) <= len(literal) && strings.EqualFold(string(literal[:len(prefix)]), partial)
+}
+
+// matchesPartial reports if the expression matches the start of the partial word.
+func matchesPartial(expr Expression, partial string, parseOpts *ParseOptions) bool {
+	if partial == "" {
+		return true
+	}
+	result := expr.matchWithCache(partial, parseOpts.withPos(0), newNodeCache())
+	return result.isMatchedNode() && result.Node.End > 0
+}
+
+// describeCategory names the category of the terminal expression, by the nearest enclosing rule
+// if the expression isn't named.
+func describeCategory(expr Expression, rules map[Expression]string) string {
+	if expr.DisplayName() != "" {
+		return expr.DisplayName()
+	}
+	if expr.ExprName() != "" {
+		return expr.ExprName()
+	}
+	if rule, ok := rules[expr]; ok {
+		return rule
+	}
+	return expr.(exprImpl).asRule()
+}
+
+// enclosingRules maps the unnamed expressions of the rules to the name of the rule enclosing them.
+func enclosingRules(rules map[string]Expression) map[Expression]string {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	rv := make(map[Expression]string)
+	var walk func(name string, expr Expression)
+	walk = func(name string, expr Expression) {
+		for _, member := range membersOf(expr) {
+			if _, ok := rv[member]; ok || member.ExprName() != "" {
+				continue
+			}
+			rv[member] = name
+			walk(name, member)
+		}
+	}
+	for _, name := range names {
+		walk(rules[name].ExprName(), rules[name])
+	}
+	return rv
+}
@@ -0,0 +1,33 @@
+package types
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Grammar_Suggest_Lookahead(t *testing.T) {
+	// statement = "let" _ name
+	// name = !keyword ~"[a-z]+"
+	// keyword = "let" / "in"
+	keyword := NewOneOf("keyword", []Expression{NewLiteral("let"), NewLiteral("in")})
+	name := NewSequence("name", []Expression{
+		NewNot(keyword),
+		NewRegex("", regexp2.MustCompile(`^(?:[a-z]+)`, regexp2.RE2)),
+	})
+	statement := NewSequence("statement", []Expression{
+		NewLiteral("let"),
+		NewRegex("_", regexp2.MustCompile(`^\s+`, regexp2.RE2)),
+		name,
+	})
+	grammar := NewGrammar(map[string]Expression{"statement": statement, "name": name, "keyword": keyword}, statement)
+
+	var texts []string
+	for _, s := range grammar.Suggest("let ", 4) {
+		texts = append(texts, fmt.Sprintf("%s:%s", s.Kind, s.Text))
+	}
+	// the keywords attempted by the lookahead aren't suggested, and the regex is named by its rule
+	assert.Equal(t, []string{"category:name"}, texts)
+}
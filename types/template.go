@@ -0,0 +1,101 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RuleTemplate is a parameterized rule, like sep_by(item, sep) = item (sep item)*.
+//
+// A template doesn't match by itself. It's expanded when a lazy reference with arguments
+// is resolved, into a copy of the body of which references to the parameters are bound to
+// the arguments. Each use gets its own expression named by the instantiation, like sep_by(identifier, ",").
+type RuleTemplate struct {
+	expression
+
+	name   string
+	params []string
+	body   Expression
+}
+
+var _ Expression = (*RuleTemplate)(nil)
+var _ exprImpl = (*RuleTemplate)(nil)
+
+func NewRuleTemplate(name string, params []string, body Expression) *RuleTemplate {
+	rv := &RuleTemplate{
+		name:   name,
+		params: params,
+		body:   body,
+	}
+	rv.expression = expression{impl: rv}
+
+	return rv
+}
+
+func (t *RuleTemplate) exprName() string {
+	return t.name
+}
+
+func (t *RuleTemplate) setExprName(n string) {
+	t.name = n
+}
+
+func (t *RuleTemplate) uncachedMatch(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	return matchFailed(fmt.Errorf("parameterized rule %q is matched without arguments", t.name))
+}
+
+func (t *RuleTemplate) asRule() string {
+	return fmt.Sprintf("%s(%s) = %s", t.name, strings.Join(t.params, ", "), joinExpressionAsRule(t.body))
+}
+
+// instantiate expands the template with the resolved arguments.
+func (t *RuleTemplate) instantiate(args []Expression, refs map[string]Expression) (Expression, error) {
+	if len(args) != len(t.params) {
+		return nil, fmt.Errorf("parameterized rule %q expects %d arguments, got %d", t.name, len(t.params), len(args))
+	}
+
+	argNames := make([]string, len(args))
+	for idx, arg := range args {
+		argNames[idx] = describeArgument(arg)
+	}
+	name := fmt.Sprintf("%s(%s)", t.name, strings.Join(argNames, ", "))
+	if instance, ok := refs[name]; ok {
+		// a recursive use with the same arguments
+		return instance, nil
+	}
+
+	// the references in the body are resolved lexically: the parameters are renamed to the names
+	// bound to the arguments in this instance, so the parameters of the templates using this one
+	// don't shadow the rules referenced by the body
+	u := newRefsUnresolver(nil)
+	u.params = make(map[string]string, len(t.params))
+	bindings := make(map[string]Expression, len(refs)+len(args)+1)
+	for k, v := range refs {
+		bindings[k] = v
+	}
+	for idx, param := range t.params {
+		bound := fmt.Sprintf("%s.%s", name, param)
+		u.params[param] = bound
+		bindings[bound] = args[idx]
+	}
+
+	instance := u.unresolveRule(t.body)
+	if instance != t.body {
+		instance.SetExprName(name)
+		instance.SetDisplayName(t.DisplayName())
+		instance.SetAnnotations(t.Annotations())
+		bindings[name] = instance
+	}
+	return ResolveRefsFor(instance, bindings)
+}
+
+// describeArgument returns the name of the argument if it's a rule, or the argument itself.
+func describeArgument(arg Expression) string {
+	if arg.ExprName() != "" {
+		return arg.ExprName()
+	}
+	if impl, ok := arg.(exprImpl); ok {
+		return impl.asRule()
+	}
+	return arg.String()
+}
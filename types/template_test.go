@@ -0,0 +1,36 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RuleTemplate_LexicalScope(t *testing.T) {
+	// a(x) = b(",") x
+	// b(y) = y x
+	// x = "g"
+	a := NewRuleTemplate("a", []string{"x"}, NewSequence("", []Expression{
+		NewLazyReferenceWithArgs("b", []Expression{NewLiteral(",")}),
+		NewLazyReference("x"),
+	}))
+	b := NewRuleTemplate("b", []string{"y"}, NewSequence("", []Expression{
+		NewLazyReference("y"),
+		NewLazyReference("x"),
+	}))
+	x := NewLiteralWithName("x", "g")
+	start := NewSequence("start", []Expression{
+		NewLazyReferenceWithArgs("a", []Expression{NewLiteral("1")}),
+		NewEOF(),
+	})
+
+	rules := map[string]Expression{"start": start, "a": a, "b": b, "x": x}
+	resolved, err := ResolveRefsFor(start, rules)
+	assert.NoError(t, err)
+
+	// the x in b is the rule x, not the parameter of a
+	_, err = ParseWithExpression(resolved, ",g1")
+	assert.NoError(t, err)
+	_, err = ParseWithExpression(resolved, ",11")
+	assert.Error(t, err)
+}
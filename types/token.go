@@ -0,0 +1,173 @@
+package types
+
+import (
+	"errors"
+	"strings"
+	"unicode/utf8"
+)
+
+// Token is a token of the input in token mode.
+type Token struct {
+	// Rule is the type of the token, e.g. the name of the lexer rule which produced it.
+	Rule string
+	// Start is the rune start index of the token in the source text.
+	Start int
+	// End is the rune end index of the token in the source text.
+	End int
+	// Text is the text of the token.
+	Text string
+}
+
+// tokenPlaceholder stands for a token in the text matched in token mode,
+// so the rune positions of the text are the token indexes.
+const tokenPlaceholder = '￼'
+
+// parseWithTokens enables token mode on parsing.
+func parseWithTokens(tokens []Token) func(*ParseOptions) {
+	return func(opts *ParseOptions) {
+		opts.tokens = tokens
+	}
+}
+
+// ParseTokensWithExpression parses the tokens with the expression in token mode.
+//
+// In token mode, literals match the type of a token, and regexes and character classes
+// match the whole text of a token. The positions of the nodes are token indexes, which can
+// be mapped back to the source text with TokenSpan. The text of a node is the concatenated
+// text of its tokens.
+//
+// The other expressions aren't token-aware, and see the text being parsed as a U+FFFC
+// placeholder for each token: any character (.) matches any token, the indentation
+// expressions (INDENT, DEDENT and SAMEDENT) don't see the indentation of the source text,
+// and the Go functions get the placeholders as MatchContext.Text, with the tokens in MatchContext.Tokens.
+func ParseTokensWithExpression(expr Expression, tokens []Token, opts ...ParseOption) (*Node, error) {
+	if tokens == nil {
+		// token mode is enabled by non-nil tokens
+		tokens = []Token{}
+	}
+	text := strings.Repeat(string(tokenPlaceholder), len(tokens))
+	opts = append(opts, parseWithTokens(tokens))
+
+	node, err := ParseWithExpression(expr, text, opts...)
+	if node != nil && !createParseOpts(opts...).lean {
+		setTokenTexts(node, tokens, make(map[*Node]struct{}))
+	}
+	if err != nil {
+		return node, withTokens(err, tokens)
+	}
+	return node, nil
+}
+
+// TokenSpan returns the rune span in the source text of the node parsed in token mode.
+func TokenSpan(tokens []Token, node *Node) (int, int) {
+	switch {
+	case node.Start < node.End:
+		return tokens[node.Start].Start, tokens[node.End-1].End
+	case node.Start < len(tokens):
+		return tokens[node.Start].Start, tokens[node.Start].Start
+	case len(tokens) > 0:
+		return tokens[len(tokens)-1].End, tokens[len(tokens)-1].End
+	default:
+		return 0, 0
+	}
+}
+
+func setTokenTexts(node *Node, tokens []Token, visited map[*Node]struct{}) {
+	if _, ok := visited[node]; ok {
+		return
+	}
+	visited[node] = struct{}{}
+
+	var sb strings.Builder
+	for _, token := range tokens[node.Start:node.End] {
+		sb.WriteString(token.Text)
+	}
+	node.Text = sb.String()
+	for _, child := range node.Children {
+		setTokenTexts(child, tokens, visited)
+	}
+	for _, trivia := range node.Trivia {
+		setTokenTexts(trivia, tokens, visited)
+	}
+}
+
+// withTokens sets the tokens on the parse errors, so they're described with the tokens.
+func withTokens(err error, tokens []Token) error {
+	if recovered, ok := err.(*ErrRecovered); ok {
+		for _, failure := range recovered.Failures {
+			failure.Tokens = tokens
+		}
+		return err
+	}
+
+	var parseFailed interface{ parseFailed() *ErrParseFailed }
+	if errors.As(err, &parseFailed) {
+		parseFailed.parseFailed().Tokens = tokens
+	}
+	return err
+}
+
+// tokenAt returns the token at the position in token mode.
+func (opts *ParseOptions) tokenAt(pos int) (Token, bool) {
+	if pos < 0 || pos >= len(opts.tokens) {
+		return Token{}, false
+	}
+	return opts.tokens[pos], true
+}
+
+// matchToken matches the type of the token at the position.
+func (l *Literal) matchToken(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	if l.literal == "" {
+		// as in text mode, the empty literal always matches
+		return matchedNode(cache.newNode(l, text, pos, pos))
+	}
+	cache.examine(pos + 1)
+	token, ok := parseOpts.tokenAt(pos)
+	if !ok {
+		return noMatch()
+	}
+	if token.Rule == l.literal || (l.caseInsensitive && strings.EqualFold(token.Rule, l.literal)) {
+		return matchedNode(cache.newNode(l, text, pos, pos+1))
+	}
+	return noMatch()
+}
+
+// matchToken matches the whole text of the token at the position, the match should cover the whole text.
+// The capture groups are positioned in the source text.
+func (r *Regex) matchToken(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	cache.examine(pos + 1)
+	token, ok := parseOpts.tokenAt(pos)
+	if !ok {
+		return noMatch()
+	}
+
+	re := r.re
+	if r.tokenRe != nil {
+		re = r.tokenRe
+	}
+	m, err := re.FindStringMatch(token.Text)
+	if err != nil {
+		return matchFailed(err)
+	}
+	if m == nil || m.Index != 0 || m.Length != utf8.RuneCountInString(token.Text) {
+		return noMatch()
+	}
+	return matchedNode(cache.newRegexNode(r, text, pos, pos+1, m.String(), r.groups(m, token.Start)))
+}
+
+// matchToken matches the token at the position, of which text is a single character in the set.
+func (c *CharClass) matchToken(text string, parseOpts *ParseOptions, cache *nodeCache) *matchResult {
+	pos := parseOpts.pos
+	cache.examine(pos + 1)
+	token, ok := parseOpts.tokenAt(pos)
+	if !ok || utf8.RuneCountInString(token.Text) != 1 {
+		return noMatch()
+	}
+	ch, _ := utf8.DecodeRuneInString(token.Text)
+	if c.contains(ch) == c.negated {
+		return noMatch()
+	}
+	return matchedNode(cache.newNode(c, text, pos, pos+1))
+}
@@ -0,0 +1,29 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/dlclark/regexp2"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Regex_MatchToken(t *testing.T) {
+	tokens := []Token{{Rule: "WORD", Start: 0, End: 2, Text: "ab"}}
+
+	// the leftmost match "a" doesn't cover the token
+	leftmost := NewRegex("word", regexp2.MustCompile(`^(?:a|ab)`, regexp2.RE2))
+	_, err := ParseTokensWithExpression(leftmost, tokens)
+	assert.Error(t, err)
+
+	anchored := NewRegexWithTokenRegex(
+		"word",
+		regexp2.MustCompile(`^(?:a|ab)`, regexp2.RE2),
+		regexp2.MustCompile(`^(?:a|ab)\z`, regexp2.RE2),
+	)
+	node, err := ParseTokensWithExpression(anchored, tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, "ab", node.Text)
+
+	_, err = ParseTokensWithExpression(anchored, []Token{{Rule: "WORD", Start: 0, End: 3, Text: "abc"}})
+	assert.Error(t, err)
+}